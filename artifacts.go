@@ -0,0 +1,133 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FoundryStorageLayout returns the storage layout of contract name from a Foundry out directory, the
+// artifact of Name compiled from File.sol is out/File.sol/Name.json. name is "Name" or "File.sol:Name",
+// the layout is only written when foundry.toml sets extra_output = ["storageLayout"]
+func FoundryStorageLayout(outDir string, name string) (string, error) {
+	// id "File.sol:Name" to artifact path
+	artifacts := make(map[string]string)
+	err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" || !strings.HasSuffix(filepath.Dir(path), ".sol") {
+			return nil
+		}
+		file, err := filepath.Rel(outDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		// Name.json, or Name.0.8.19.json when several compiler versions build the same contract
+		contract := strings.SplitN(filepath.Base(path), ".", 2)[0]
+		id := filepath.ToSlash(file) + ":" + contract
+		if prev, ok := artifacts[id]; !ok || path < prev {
+			artifacts[id] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("read foundry artifacts error: %w", err)
+	}
+
+	if len(artifacts) == 0 {
+		return "", fmt.Errorf("no foundry artifacts in %s", outDir)
+	}
+	ids := make([]string, 0, len(artifacts))
+	for id := range artifacts {
+		ids = append(ids, id)
+	}
+	id, err := selectContract(ids, name)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(artifacts[id])
+	if err != nil {
+		return "", err
+	}
+	var artifact struct {
+		StorageLayout json.RawMessage `json:"storageLayout"`
+	}
+	if err = json.Unmarshal(data, &artifact); err != nil {
+		return "", fmt.Errorf("parse foundry artifact %s error: %w", artifacts[id], err)
+	}
+	return selectLayout(map[string]json.RawMessage{id: artifact.StorageLayout}, id,
+		`set extra_output = ["storageLayout"] in foundry.toml`)
+}
+
+// HardhatStorageLayout returns the storage layout of contract name from the build info files of a Hardhat
+// artifacts directory, artifacts/build-info/*.json hold the solc standard json output of every build.
+// name is "Name" or "contracts/File.sol:Name", the layout is only written when the solidity settings
+// of hardhat.config request storageLayout in outputSelection
+func HardhatStorageLayout(artifactsDir string, name string) (string, error) {
+	dir := filepath.Join(artifactsDir, "build-info")
+	if _, err := os.Stat(dir); err != nil {
+		// artifactsDir is the build-info directory itself
+		dir = artifactsDir
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	layouts := make(map[string]json.RawMessage)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		var buildInfo struct {
+			Output struct {
+				Contracts json.RawMessage `json:"contracts"`
+			} `json:"output"`
+		}
+		if err = json.Unmarshal(data, &buildInfo); err != nil {
+			return "", fmt.Errorf("parse hardhat build info %s error: %w", file, err)
+		}
+		if len(buildInfo.Output.Contracts) == 0 {
+			continue
+		}
+		built, err := standardJsonLayouts(buildInfo.Output.Contracts)
+		if err != nil {
+			return "", fmt.Errorf("parse hardhat build info %s error: %w", file, err)
+		}
+		for id, layout := range built {
+			if _, ok := layouts[id]; !ok || len(layouts[id]) == 0 {
+				layouts[id] = layout
+			}
+		}
+	}
+	if len(layouts) == 0 {
+		return "", fmt.Errorf("no hardhat build info in %s", dir)
+	}
+	return selectLayout(layouts, name, "request storageLayout in the outputSelection of hardhat.config")
+}
+
+// ParseByFoundryArtifacts parses the storage layout of contract name from a Foundry out directory
+func (c *Contract) ParseByFoundryArtifacts(outDir string, name string) error {
+	layout, err := FoundryStorageLayout(outDir, name)
+	if err != nil {
+		return err
+	}
+	return c.ParseByStorageLayout(layout)
+}
+
+// ParseByHardhatArtifacts parses the storage layout of contract name from a Hardhat artifacts directory
+func (c *Contract) ParseByHardhatArtifacts(artifactsDir string, name string) error {
+	layout, err := HardhatStorageLayout(artifactsDir, name)
+	if err != nil {
+		return err
+	}
+	return c.ParseByStorageLayout(layout)
+}
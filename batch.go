@@ -0,0 +1,198 @@
+package storagescan
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"sync"
+)
+
+const (
+	// maxPrefetchRounds bounds the dependency depth followed while planning, e.g. slice -> string header -> string data
+	maxPrefetchRounds = 8
+
+	// maxPrefetchSlots bounds the number of slots planned by one accessor and kept in memory for one value,
+	// the rest is read on demand
+	maxPrefetchSlots = 10000
+)
+
+var errPrefetchBudget = errors.New("prefetch budget exceeded")
+
+// BatchStorageReader is a StorageReader able to read several slots in one round trip
+type BatchStorageReader interface {
+	StorageReader
+
+	BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error)
+}
+
+// BatchGetValueStorageAtFunc reads several slots at once, the values are in the order of slots
+type BatchGetValueStorageAtFunc func(slots []common.Hash) ([][]byte, error)
+
+// GenReaderBatchStorageValueFunc binds a batch reader to a contract address and block
+func GenReaderBatchStorageValueFunc(ctx context.Context, reader BatchStorageReader, contractAddr common.Address, block *rpc.BlockNumberOrHash) BatchGetValueStorageAtFunc {
+	return func(slots []common.Hash) ([][]byte, error) {
+		return reader.BatchStorageAt(ctx, contractAddr, slots, block)
+	}
+}
+
+// batchStorageAt reads slots in one round trip when reader supports it, one by one otherwise
+func batchStorageAt(ctx context.Context, reader StorageReader, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	if br, ok := reader.(BatchStorageReader); ok {
+		return br.BatchStorageAt(ctx, account, slots, block)
+	}
+	values := make([][]byte, len(slots))
+	for i, s := range slots {
+		value, err := reader.StorageAt(ctx, account, s, block)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// batchedValue is a composite value whose accessors plan and batch their reads
+type batchedValue interface {
+	// withReader returns a copy of the value reading with f, batcher batches the reads of its accessors
+	withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{}
+}
+
+// slotBatcher reads the slots of one decoded value, before reading, an accessor plans the slots it is
+// about to touch, e.g. one page of a slice, and fetches them in one batch, the fetched slots are kept
+// in memory for the reads of the accessor and of the values it returns
+type slotBatcher struct {
+	f GetValueStorageAtFunc
+
+	batch BatchGetValueStorageAtFunc
+
+	mu sync.RWMutex
+
+	values map[common.Hash][]byte
+}
+
+func newSlotBatcher(f GetValueStorageAtFunc, batch BatchGetValueStorageAtFunc) *slotBatcher {
+	return &slotBatcher{
+		f:      f,
+		batch:  batch,
+		values: map[common.Hash][]byte{},
+	}
+}
+
+// storageAt serves a fetched slot from memory and reads any other slot with f
+func (b *slotBatcher) storageAt(s common.Hash) ([]byte, error) {
+	b.mu.RLock()
+	v, ok := b.values[s]
+	b.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+	return b.f(s)
+}
+
+// release empties the memory once it holds more than maxPrefetchSlots, it bounds the memory of long
+// iterations, slots dropped while still in use are read again on demand
+func (b *slotBatcher) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.values) > maxPrefetchSlots {
+		b.values = map[common.Hash][]byte{}
+	}
+}
+
+// prefetch finds the slots read touches by running it with zero values for the slots not in memory,
+// and fetches them in one batch, the slots read may depend on the values of the fetched ones, e.g. the
+// data of a long string on its header, so planning is repeated until read needs nothing new
+func (b *slotBatcher) prefetch(read func(f GetValueStorageAtFunc)) error {
+	for round := 0; round < maxPrefetchRounds; round++ {
+		var missing []common.Hash
+		requested := map[common.Hash]bool{}
+		planned := 0
+		plan := func(s common.Hash) ([]byte, error) {
+			planned++
+			if planned > maxPrefetchSlots {
+				return nil, errPrefetchBudget
+			}
+			b.mu.RLock()
+			value, ok := b.values[s]
+			b.mu.RUnlock()
+			if ok {
+				return value, nil
+			}
+			if !requested[s] {
+				requested[s] = true
+				missing = append(missing, s)
+			}
+			return common.Hash{}.Bytes(), nil
+		}
+		read(plan)
+
+		if len(missing) == 0 {
+			return nil
+		}
+		values, err := b.batch(missing)
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		for i, s := range missing {
+			b.values[s] = values[i]
+		}
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// decodeBatched decodes v with f, with a batcher the slots read by Value are fetched in batches, e.g. the
+// data slots of a long string, and a composite value gets the batcher for its accessors
+func decodeBatched(v Variable, f GetValueStorageAtFunc, b *slotBatcher) (interface{}, error) {
+	if b == nil {
+		return v.Value(f)
+	}
+	err := b.prefetch(func(plan GetValueStorageAtFunc) {
+		// decoding errors are reported by the real read
+		_, _ = v.Value(plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, err := v.Value(f)
+	if err != nil {
+		return nil, err
+	}
+	return withBatcher(value, f, b), nil
+}
+
+// withBatcher gives b to value when it is a composite value decoded with f
+func withBatcher(value interface{}, f GetValueStorageAtFunc, b *slotBatcher) interface{} {
+	if bv, ok := value.(batchedValue); ok && b != nil {
+		return bv.withReader(f, b)
+	}
+	return value
+}
+
+// expandValue reads what formatting value reads beyond its own slots, the members of a struct and the
+// elements of a slice up to maxStringElements, it returns false once a read fails
+func expandValue(value interface{}) bool {
+	switch v := value.(type) {
+	case StructValue:
+		for name := range v.filedValueMap {
+			field, err := v.Field(name)
+			if err != nil || !expandValue(field) {
+				return false
+			}
+		}
+	case SliceArrayValueI:
+		shown := v.Len()
+		if shown > maxStringElements {
+			shown = maxStringElements
+		}
+		for i := uint64(0); i < shown; i++ {
+			element, err := v.Index(i)
+			if err != nil || !expandValue(element) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package storagescan
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// countingReader is a batch reader over a snapshot counting the requests and the slots read
+type countingReader struct {
+	*SnapshotReader
+
+	batches, singles, slots int
+}
+
+func (r *countingReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	r.singles++
+	r.slots++
+	return r.SnapshotReader.StorageAt(ctx, account, slot, block)
+}
+
+func (r *countingReader) BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	r.batches++
+	r.slots += len(slots)
+	values := make([][]byte, len(slots))
+	for i, s := range slots {
+		values[i], _ = r.SnapshotReader.StorageAt(ctx, account, s, block)
+	}
+	return values, nil
+}
+
+func (r *countingReader) reset() {
+	r.batches, r.singles, r.slots = 0, 0, 0
+}
+
+const batchLayout = `{"storage":[
+{"label":"big","offset":0,"slot":"0","type":"t_array(t_uint256)dyn_storage"},
+{"label":"names","offset":0,"slot":"1","type":"t_array(t_string_storage)dyn_storage"},
+{"label":"items","offset":0,"slot":"2","type":"t_array(t_struct(Item)10_storage)dyn_storage"},
+{"label":"byId","offset":0,"slot":"3","type":"t_mapping(t_uint256,t_string_storage)"}],
+"types":{
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_string_storage":{"encoding":"bytes","label":"string","numberOfBytes":"32"},
+"t_array(t_uint256)dyn_storage":{"base":"t_uint256","encoding":"dynamic_array","label":"uint256[]","numberOfBytes":"32"},
+"t_array(t_string_storage)dyn_storage":{"base":"t_string_storage","encoding":"dynamic_array","label":"string[]","numberOfBytes":"32"},
+"t_array(t_struct(Item)10_storage)dyn_storage":{"base":"t_struct(Item)10_storage","encoding":"dynamic_array","label":"struct Item[]","numberOfBytes":"32"},
+"t_struct(Item)10_storage":{"encoding":"inplace","label":"struct Item","numberOfBytes":"64","members":[
+{"label":"id","offset":0,"slot":"0","type":"t_uint256"},{"label":"name","offset":0,"slot":"1","type":"t_string_storage"}]},
+"t_mapping(t_uint256,t_string_storage)":{"encoding":"mapping","key":"t_uint256","label":"mapping(uint256 => string)","numberOfBytes":"32","value":"t_string_storage"}}}`
+
+// testStorage builds contract storage in tests
+type testStorage map[common.Hash]common.Hash
+
+func slotOf(n uint64) common.Hash {
+	return common.BigToHash(new(big.Int).SetUint64(n))
+}
+
+// dataSlot returns the slot i words after keccak256(slot)
+func dataSlot(slot common.Hash, i uint64) common.Hash {
+	return common.BigToHash(new(big.Int).Add(crypto.Keccak256Hash(slot.Bytes()).Big(), new(big.Int).SetUint64(i)))
+}
+
+// queryTest is the expected fmt.Sprint of the value at a Contract.Query path
+type queryTest struct {
+	path string
+	want string
+}
+
+// checkQueries runs tests on a contract of layout over st, read slot by slot and then with batches
+func checkQueries(t *testing.T, layout string, st testStorage, tests []queryTest) {
+	t.Helper()
+	checkParsedQueries(t, st, tests, func(reader StorageReader) (*Contract, error) {
+		return NewContractFromLayout(common.Address{}, reader, layout)
+	})
+}
+
+// checkParsedQueries is checkQueries for the contracts returned by parse
+func checkParsedQueries(t *testing.T, st testStorage, tests []queryTest, parse func(reader StorageReader) (*Contract, error)) {
+	t.Helper()
+	snapshot := NewSnapshotReader(common.Address{}, st)
+	for _, reader := range []StorageReader{snapshot, &countingReader{SnapshotReader: snapshot}} {
+		c, err := parse(reader)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		for _, tt := range tests {
+			v, err := c.Query(tt.path)
+			if err != nil {
+				t.Errorf("%T: query %s error: %v", reader, tt.path, err)
+				continue
+			}
+			if got := fmt.Sprint(v); got != tt.want {
+				t.Errorf("%T: query %s = %s, want %s", reader, tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+// putString stores a solidity string at slot
+func (st testStorage) putString(slot common.Hash, s string) {
+	if len(s) < 32 {
+		var h common.Hash
+		copy(h[:], s)
+		h[31] = byte(len(s) * 2)
+		st[slot] = h
+		return
+	}
+	st[slot] = slotOf(uint64(len(s)*2 + 1))
+	for i := 0; i*32 < len(s); i++ {
+		var h common.Hash
+		copy(h[:], s[i*32:])
+		st[dataSlot(slot, uint64(i))] = h
+	}
+}
+
+func TestBatchedReads(t *testing.T) {
+	st := testStorage{}
+	const bigLength = 50000
+	st[slotOf(0)] = slotOf(bigLength)
+	for i := uint64(0); i < bigLength; i++ {
+		st[dataSlot(slotOf(0), i)] = slotOf(i)
+	}
+	long := strings.Repeat("long string spanning data slots ", 3)
+	st[slotOf(1)] = slotOf(3)
+	st.putString(dataSlot(slotOf(1), 0), "a")
+	st.putString(dataSlot(slotOf(1), 1), long)
+	st.putString(dataSlot(slotOf(1), 2), long)
+	st[slotOf(2)] = slotOf(2)
+	for i := uint64(0); i < 2; i++ {
+		st[dataSlot(slotOf(2), i*2)] = slotOf(i + 1)
+		st.putString(dataSlot(slotOf(2), i*2+1), fmt.Sprintf("item%d", i))
+	}
+	st.putString(crypto.Keccak256Hash(slotOf(7).Bytes(), slotOf(3).Bytes()), long)
+
+	reader := &countingReader{SnapshotReader: NewSnapshotReader(common.Address{}, st)}
+	c, err := NewContractFromLayout(common.Address{}, reader, batchLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		read func() (interface{}, error)
+
+		want string
+
+		// maximum batch calls and slots read
+		batches, slots int
+	}{
+		{
+			name:    "slice handle reads the length",
+			read:    func() (interface{}, error) { return c.GetVariableValue("big") },
+			want:    "...49900 more]",
+			batches: 1, slots: 1,
+		},
+		{
+			name:    "last element",
+			read:    func() (interface{}, error) { return c.Query("big[49999]") },
+			want:    "49999",
+			batches: 2, slots: 2,
+		},
+		{
+			name: "page",
+			read: func() (interface{}, error) {
+				v, err := c.GetVariableValue("big")
+				if err != nil {
+					return nil, err
+				}
+				return v.(SliceArrayValueI).Slice(0, 10)
+			},
+			want:    "[0 1 2 3 4 5 6 7 8 9]",
+			batches: 2, slots: 11,
+		},
+		{
+			name: "range stopped early",
+			read: func() (interface{}, error) {
+				v, err := c.GetVariableValue("big")
+				if err != nil {
+					return nil, err
+				}
+				var seen []interface{}
+				err = v.(SliceArrayValueI).Range(func(i uint64, e interface{}) bool {
+					seen = append(seen, e)
+					return i < 2
+				})
+				return seen, err
+			},
+			want:    "[0 1 2]",
+			batches: 2, slots: 1 + prefetchPage,
+		},
+		{
+			name: "strings with data slots",
+			read: func() (interface{}, error) {
+				v, err := c.GetVariableValue("names")
+				if err != nil {
+					return nil, err
+				}
+				return v.(SliceArrayValueI).String(), nil
+			},
+			want:    "[a " + long + " " + long + "]",
+			batches: 3, slots: 1 + 3 + 6,
+		},
+		{
+			name: "struct members",
+			read: func() (interface{}, error) {
+				v, err := c.GetVariableValue("items")
+				if err != nil {
+					return nil, err
+				}
+				return v.(SliceArrayValueI).String(), nil
+			},
+			want:    "name:item1",
+			batches: 2, slots: 1 + 4,
+		},
+		{
+			name:    "mapping string value",
+			read:    func() (interface{}, error) { return c.Query("byId[7]") },
+			want:    long,
+			batches: 2, slots: 1 + 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader.reset()
+			v, err := tt.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reader.singles > 0 || reader.batches > tt.batches || reader.slots > tt.slots {
+				t.Errorf("read %d slots in %d batches and %d single reads, want at most %d slots in %d batches",
+					reader.slots, reader.batches, reader.singles, tt.slots, tt.batches)
+			}
+			if got := fmt.Sprint(v); !strings.Contains(got, tt.want) {
+				t.Errorf("value = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package storagescan
+
+import (
+	"container/list"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"sync"
+)
+
+// SlotCacheStats counts the lookups served by a SlotCache
+type SlotCacheStats struct {
+	Hits uint64 `json:"hits"`
+
+	Misses uint64 `json:"misses"`
+
+	Evictions uint64 `json:"evictions"`
+
+	// Invalidations is the number of times the cache was emptied because the block changed
+	Invalidations uint64 `json:"invalidations"`
+}
+
+type slotCacheKey struct {
+	address common.Address
+
+	block string
+
+	slot common.Hash
+}
+
+type slotCacheEntry struct {
+	key slotCacheKey
+
+	value []byte
+}
+
+// SlotCache is a LRU cache of storage slots keyed by contract address, block and slot, it is safe for concurrent use
+// reads at the latest block are cached under "latest", pin the block or Purge the cache when fresh state is needed
+type SlotCache struct {
+	mu sync.Mutex
+
+	size int
+
+	entries map[slotCacheKey]*list.Element
+
+	lru *list.List
+
+	invalidateOnBlockChange bool
+
+	lastBlock string
+
+	stats SlotCacheStats
+}
+
+// NewSlotCache returns a cache holding at most size slots, when invalidateOnBlockChange is true every entry
+// is dropped as soon as a slot of another block is read
+func NewSlotCache(size int, invalidateOnBlockChange bool) *SlotCache {
+	return &SlotCache{
+		size:                    size,
+		entries:                 map[slotCacheKey]*list.Element{},
+		lru:                     list.New(),
+		invalidateOnBlockChange: invalidateOnBlockChange,
+	}
+}
+
+func blockKey(block *rpc.BlockNumberOrHash) string {
+	if block == nil {
+		return "latest"
+	}
+	if number, ok := block.Number(); ok {
+		text, _ := number.MarshalText()
+		return string(text)
+	}
+	return block.String()
+}
+
+func (c *SlotCache) get(key slotCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.invalidateOnBlockChange && c.lastBlock != key.block {
+		if c.lastBlock != "" && c.lru.Len() > 0 {
+			c.purge()
+			c.stats.Invalidations++
+		}
+		c.lastBlock = key.block
+	}
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		return e.Value.(*slotCacheEntry).value, true
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+func (c *SlotCache) add(key slotCacheKey, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.invalidateOnBlockChange && c.lastBlock != key.block {
+		// the block changed while the slot was read
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		e.Value.(*slotCacheEntry).value = value
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&slotCacheEntry{key: key, value: value})
+	for c.size > 0 && c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*slotCacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *SlotCache) purge() {
+	c.entries = map[slotCacheKey]*list.Element{}
+	c.lru.Init()
+}
+
+// Purge drops every cached slot
+func (c *SlotCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge()
+}
+
+// Len returns the number of cached slots
+func (c *SlotCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the counters since the cache was created
+func (c *SlotCache) Stats() SlotCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Wrap caches the slots read by f, which must read contractAddr at block
+func (c *SlotCache) Wrap(contractAddr common.Address, block *rpc.BlockNumberOrHash, f GetValueStorageAtFunc) GetValueStorageAtFunc {
+	bk := blockKey(block)
+	return func(s common.Hash) ([]byte, error) {
+		key := slotCacheKey{address: contractAddr, block: bk, slot: s}
+		if value, ok := c.get(key); ok {
+			return value, nil
+		}
+		value, err := f(s)
+		if err != nil {
+			return nil, err
+		}
+		c.add(key, value)
+		return value, nil
+	}
+}
+
+// Reader returns a StorageReader serving slots from the cache and reading the misses with reader
+func (c *SlotCache) Reader(reader StorageReader) BatchStorageReader {
+	return &cachedStorageReader{
+		cache:  c,
+		reader: reader,
+	}
+}
+
+type cachedStorageReader struct {
+	cache *SlotCache
+
+	reader StorageReader
+}
+
+func (r *cachedStorageReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	return r.cache.Wrap(account, block, func(s common.Hash) ([]byte, error) {
+		return r.reader.StorageAt(ctx, account, s, block)
+	})(slot)
+}
+
+func (r *cachedStorageReader) BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	bk := blockKey(block)
+	values := make([][]byte, len(slots))
+	var missing []common.Hash
+	var missingIndex []int
+	for i, s := range slots {
+		if value, ok := r.cache.get(slotCacheKey{address: account, block: bk, slot: s}); ok {
+			values[i] = value
+			continue
+		}
+		missing = append(missing, s)
+		missingIndex = append(missingIndex, i)
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	fetched, err := batchStorageAt(ctx, r.reader, account, missing, block)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range fetched {
+		r.cache.add(slotCacheKey{address: account, block: bk, slot: missing[i]}, value)
+		values[missingIndex[i]] = value
+	}
+	return values, nil
+}
+
+// Close closes the underlying reader
+func (r *cachedStorageReader) Close() {
+	r.reader.Close()
+}
@@ -0,0 +1,133 @@
+package storagescan
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// readSlot reads slot n through f and fails the test on error
+func readSlot(t *testing.T, f GetValueStorageAtFunc, n uint64) {
+	t.Helper()
+	if _, err := f(slotOf(n)); err != nil {
+		t.Fatalf("read slot %d error: %v", n, err)
+	}
+}
+
+func TestSlotCacheEviction(t *testing.T) {
+	cache := NewSlotCache(2, false)
+	reads := map[common.Hash]int{}
+	f := cache.Wrap(common.Address{}, nil, func(s common.Hash) ([]byte, error) {
+		reads[s]++
+		return s.Bytes(), nil
+	})
+	readSlot(t, f, 1)
+	readSlot(t, f, 2)
+	// slot 1 becomes the most recently used, slot 2 is evicted by slot 3
+	readSlot(t, f, 1)
+	readSlot(t, f, 3)
+	if cache.Len() != 2 {
+		t.Fatalf("len = %d, want 2", cache.Len())
+	}
+	readSlot(t, f, 1)
+	readSlot(t, f, 2)
+	if reads[slotOf(1)] != 1 || reads[slotOf(2)] != 2 || reads[slotOf(3)] != 1 {
+		t.Errorf("reads = %v, want slot 2 read twice and the others once", reads)
+	}
+	want := SlotCacheStats{Hits: 2, Misses: 4, Evictions: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestSlotCacheBlockChange(t *testing.T) {
+	cache := NewSlotCache(10, true)
+	value := func(s common.Hash) ([]byte, error) {
+		return s.Bytes(), nil
+	}
+	at1 := cache.Wrap(common.Address{}, BlockNumber(big.NewInt(1)), value)
+	at2 := cache.Wrap(common.Address{}, BlockNumber(big.NewInt(2)), value)
+	readSlot(t, at1, 1)
+	readSlot(t, at1, 2)
+	readSlot(t, at2, 1)
+	if cache.Len() != 1 {
+		t.Fatalf("len after block change = %d, want 1", cache.Len())
+	}
+	readSlot(t, at1, 1)
+	want := SlotCacheStats{Misses: 4, Invalidations: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	// a read of block 3 starts while a slot of block 1 is read, the slot of block 1 is not cached
+	racing := cache.Wrap(common.Address{}, BlockNumber(big.NewInt(1)), func(s common.Hash) ([]byte, error) {
+		readSlot(t, cache.Wrap(common.Address{}, BlockNumber(big.NewInt(3)), value), 9)
+		return s.Bytes(), nil
+	})
+	readSlot(t, racing, 5)
+	if cache.Len() != 1 {
+		t.Errorf("len = %d, want only the slot of block 3", cache.Len())
+	}
+}
+
+func TestCachedReaderBatch(t *testing.T) {
+	st := testStorage{slotOf(1): slotOf(10), slotOf(2): slotOf(20), slotOf(3): slotOf(30)}
+	counting := &countingReader{SnapshotReader: NewSnapshotReader(common.Address{}, st)}
+	cache := NewSlotCache(10, false)
+	reader := cache.Reader(counting)
+	ctx := context.Background()
+	block := BlockNumber(big.NewInt(7))
+
+	values, err := reader.BatchStorageAt(ctx, common.Address{}, []common.Hash{slotOf(1), slotOf(2)}, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = reader.BatchStorageAt(ctx, common.Address{}, []common.Hash{slotOf(2), slotOf(3), slotOf(1)}, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []uint64{20, 30, 10} {
+		if common.BytesToHash(values[i]) != slotOf(want) {
+			t.Errorf("value %d = %x, want %d", i, values[i], want)
+		}
+	}
+	// only slot 3 is fetched by the second batch
+	if counting.batches != 2 || counting.slots != 3 {
+		t.Errorf("batches = %d, slots = %d, want 2 batches of 3 slots", counting.batches, counting.slots)
+	}
+	want := SlotCacheStats{Hits: 2, Misses: 3}
+	if got := cache.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	// a batch of every cached slot is not sent
+	if _, err = reader.BatchStorageAt(ctx, common.Address{}, []common.Hash{slotOf(3)}, block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.BatchStorageAt(ctx, common.Address{}, []common.Hash{slotOf(3)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if counting.batches != 3 {
+		t.Errorf("batches = %d, want the latest block read only", counting.batches)
+	}
+}
+
+func TestContractCacheStats(t *testing.T) {
+	st := testStorage{slotOf(0): slotOf(3)}
+	cache := NewSlotCache(10, true)
+	c, err := NewContractFromLayout(common.Address{}, NewSnapshotReader(common.Address{}, st), uintSliceLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := c.AtBlock(big.NewInt(12000000)).WithCache(cache)
+	for i := 0; i < 2; i++ {
+		if _, err = cached.GetVariableValue("big"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := SlotCacheStats{Hits: 1, Misses: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,256 @@
+package storagescan
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	bigIntType  = reflect.TypeOf(big.Int{})
+	addressType = reflect.TypeOf(common.Address{})
+)
+
+// Decode reads the variable name into out, a non-nil pointer. Go values are filled as follows:
+//   - struct fields from the members named by their `storagescan:"member"` tag, untagged exported fields
+//     from the member named like the field with a lower case first letter, "-" skips a field
+//   - slices and arrays element by element, an array must have the length of the solidity array
+//   - maps from a mapping, only the entries of the keys already present in the map are read, nested
+//     maps list their own keys
+//   - *big.Int, int and uint kinds from integers, an integer that does not fit the Go type is an error
+//   - common.Address from an address, [N]byte and []byte from bytesN or bytes, string and bool as is
+//   - interface{} and storagescan.Value targets receive the decoded value itself
+func (c *Contract) Decode(name string, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode %s error: out must be a non-nil pointer, got %T", name, out)
+	}
+	value, err := c.GetVariableValue(name)
+	if err != nil {
+		return err
+	}
+	return decodeValue(value, rv.Elem(), name)
+}
+
+// decodeValue decodes value into rv, path names the value in errors
+func decodeValue(value interface{}, rv reflect.Value, path string) error {
+	if value == nil {
+		return fmt.Errorf("decode %s error: no value", path)
+	}
+	if reflect.TypeOf(value).AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch {
+	case rv.Type() == bigIntType:
+		return decodeBig(value, rv.Addr().Interface().(*big.Int), path)
+	case rv.Kind() == reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(value, rv.Elem(), path)
+	case rv.Type() == addressType:
+		address, ok := value.(AddressValue)
+		if !ok {
+			return decodeMismatch(value, rv, path)
+		}
+		rv.Set(reflect.ValueOf(address.Address))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, ok := value.(BoolValue)
+		if !ok {
+			return decodeMismatch(value, rv, path)
+		}
+		rv.SetBool(bool(b))
+	case reflect.String:
+		s, ok := value.(StringValue)
+		if !ok {
+			return decodeMismatch(value, rv, path)
+		}
+		rv.SetString(string(s))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := new(big.Int)
+		if err := decodeBig(value, n, path); err != nil {
+			return err
+		}
+		if !n.IsInt64() || rv.OverflowInt(n.Int64()) {
+			return fmt.Errorf("decode %s error: %v overflows %s", path, n, rv.Type())
+		}
+		rv.SetInt(n.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := new(big.Int)
+		if err := decodeBig(value, n, path); err != nil {
+			return err
+		}
+		if n.Sign() < 0 || !n.IsUint64() || rv.OverflowUint(n.Uint64()) {
+			return fmt.Errorf("decode %s error: %v overflows %s", path, n, rv.Type())
+		}
+		rv.SetUint(n.Uint64())
+	case reflect.Struct:
+		return decodeStruct(value, rv, path)
+	case reflect.Slice, reflect.Array:
+		return decodeList(value, rv, path)
+	case reflect.Map:
+		return decodeMap(value, rv, path)
+	default:
+		return decodeMismatch(value, rv, path)
+	}
+	return nil
+}
+
+func decodeMismatch(value interface{}, rv reflect.Value, path string) error {
+	return fmt.Errorf("decode %s error: can not decode %T into %s", path, value, rv.Type())
+}
+
+func decodeBig(value interface{}, n *big.Int, path string) error {
+	switch v := value.(type) {
+	case UintValue:
+		n.Set(v.Int)
+	case IntValue:
+		n.Set(v.Int)
+	default:
+		return fmt.Errorf("decode %s error: can not decode %T into %T", path, value, n)
+	}
+	return nil
+}
+
+func decodeStruct(value interface{}, rv reflect.Value, path string) error {
+	sv, ok := value.(StructValueI)
+	if !ok {
+		return decodeMismatch(value, rv, path)
+	}
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		member, ok := field.Tag.Lookup("storagescan")
+		if member == "-" {
+			continue
+		}
+		if !ok || member == "" {
+			r, size := utf8.DecodeRuneInString(field.Name)
+			member = string(unicode.ToLower(r)) + field.Name[size:]
+		}
+		fieldValue, err := sv.Field(member)
+		if err != nil {
+			return fmt.Errorf("decode %s.%s error: %w", path, member, err)
+		}
+		if err = decodeValue(fieldValue, rv.Field(i), path+"."+member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeList(value interface{}, rv reflect.Value, path string) error {
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		// bytesN into [N]byte, bytes or bytesN into []byte
+		var b []byte
+		isBytes := true
+		switch v := value.(type) {
+		case BytesNValue:
+			b = v.Bytes()
+		case DynamicBytesValue:
+			b = v.Bytes()
+		default:
+			isBytes = false
+		}
+		if isBytes {
+			if rv.Kind() == reflect.Slice {
+				rv.SetBytes(b)
+				return nil
+			}
+			if len(b) > rv.Len() {
+				return fmt.Errorf("decode %s error: %d bytes overflow %s", path, len(b), rv.Type())
+			}
+			reflect.Copy(rv, reflect.ValueOf(b))
+			return nil
+		}
+	}
+
+	sv, ok := value.(SliceArrayValueI)
+	if !ok {
+		return decodeMismatch(value, rv, path)
+	}
+	length := sv.Len()
+	if rv.Kind() == reflect.Array {
+		if uint64(rv.Len()) != length {
+			return fmt.Errorf("decode %s error: can not decode %d elements into %s", path, length, rv.Type())
+		}
+	} else {
+		// grown while decoding, the length is read from storage
+		rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
+	}
+	// read page by page with Range, the reads of a page are batched
+	var decodeErr error
+	err := sv.Range(func(i uint64, element interface{}) bool {
+		if rv.Kind() == reflect.Slice {
+			rv.Set(reflect.Append(rv, reflect.Zero(rv.Type().Elem())))
+		}
+		decodeErr = decodeValue(element, rv.Index(int(i)), fmt.Sprintf("%s[%d]", path, i))
+		return decodeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+func decodeMap(value interface{}, rv reflect.Value, path string) error {
+	mv, ok := value.(MappingValueI)
+	if !ok {
+		return decodeMismatch(value, rv, path)
+	}
+	iter := rv.MapRange()
+	type entry struct{ key, value reflect.Value }
+	var entries []entry
+	for iter.Next() {
+		k := mappingKeyString(iter.Key())
+		entryValue, err := mv.Key(k)
+		if err != nil {
+			return fmt.Errorf("decode %s[%s] error: %w", path, k, err)
+		}
+		// start from the present value, so the keys of a nested map are read too
+		target := reflect.New(rv.Type().Elem()).Elem()
+		target.Set(iter.Value())
+		if err = decodeValue(entryValue, target, fmt.Sprintf("%s[%s]", path, k)); err != nil {
+			return err
+		}
+		entries = append(entries, entry{iter.Key(), target})
+	}
+	// the map is not modified while ranging over it
+	for _, e := range entries {
+		rv.SetMapIndex(e.key, e.value)
+	}
+	return nil
+}
+
+// mappingKeyString formats a Go map key as a mapping key accepted by MappingValueI.Key
+func mappingKeyString(key reflect.Value) string {
+	switch k := key.Interface().(type) {
+	case interface{ Hex() string }:
+		// common.Address, common.Hash
+		return k.Hex()
+	case string:
+		return k
+	case fmt.Stringer:
+		return k.String()
+	}
+	if key.Kind() == reflect.Array && key.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, key.Len())
+		reflect.Copy(reflect.ValueOf(b), key)
+		return hexutil.Encode(b)
+	}
+	return fmt.Sprint(key.Interface())
+}
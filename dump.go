@@ -0,0 +1,94 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DumpJSON writes every variable of the contract as an indented JSON object keyed by variable name,
+// structs, arrays and slices are fully expanded. A mapping holds the entries of the keys listed in
+// mappingKeys under its path, e.g. "balances" for a variable, "allowance[0xowner]" for a nested
+// mapping or "entities[0].votes" for a struct member, mappings without keys are written as {}
+func (c *Contract) DumpJSON(w io.Writer, mappingKeys map[string][]string) error {
+	names := make([]string, 0, len(c.Variables))
+	for name := range c.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dump := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		value, err := c.GetVariableValue(name)
+		if err != nil {
+			return fmt.Errorf("dump %s error: %w", name, err)
+		}
+		if dump[name], err = dumpValue(value, name, mappingKeys); err != nil {
+			return fmt.Errorf("dump %s error: %w", name, err)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// dumpValue expands a decoded value into maps and slices of Value ready for json encoding,
+// path locates the value for looking up mapping keys
+func dumpValue(value interface{}, path string, mappingKeys map[string][]string) (interface{}, error) {
+	switch v := value.(type) {
+	case StructValue:
+		fields := make(map[string]interface{}, len(v.filedValueMap))
+		for name := range v.filedValueMap {
+			field, err := v.Field(name)
+			if err != nil {
+				return nil, err
+			}
+			if fields[name], err = dumpValue(field, path+"."+name, mappingKeys); err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	case MappingValue:
+		entries := make(map[string]interface{}, len(mappingKeys[path]))
+		for _, k := range mappingKeys[path] {
+			entry, err := v.Key(k)
+			if err != nil {
+				return nil, err
+			}
+			p := fmt.Sprintf("%s[%s]", path, k)
+			if entries[k], err = dumpValue(entry, p, mappingKeys); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	case SliceArrayValueI:
+		elements := []interface{}{}
+		var dumpErr error
+		err := v.Range(func(i uint64, element interface{}) bool {
+			var dumped interface{}
+			dumped, dumpErr = dumpValue(element, fmt.Sprintf("%s[%d]", path, i), mappingKeys)
+			elements = append(elements, dumped)
+			return dumpErr == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if dumpErr != nil {
+			return nil, dumpErr
+		}
+		return elements, nil
+	default:
+		return value, nil
+	}
+}
+
+// marshalValue is the json.Marshaler of the composite values, nested mappings are written as {}
+func marshalValue(value interface{}) ([]byte, error) {
+	dump, err := dumpValue(value, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dump)
+}
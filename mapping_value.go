@@ -3,15 +3,18 @@ package storagescan
 import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"math/big"
-	"reflect"
-	"strconv"
 	"strings"
 )
 
+// minInt256 is -2^255, the only int256 whose absolute value needs 256 bits
+var minInt256 = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 255))
+
 type MappingValueI interface {
-	Key(k string) interface{}
+	Key(k string) (interface{}, error)
+	Keys(keys ...string) (interface{}, error)
 	String() string
 }
 
@@ -23,79 +26,187 @@ type MappingValue struct {
 	valueTyp Variable
 
 	f GetValueStorageAtFunc
+
+	batcher *slotBatcher
+
+	// vyper derives value slots as keccak256(slot, key), with string and bytes keys hashed first
+	vyper bool
 }
 
-// slotIndex = abi.encode(key,slot)
-func (m MappingValue) Key(k string) interface{} {
+// slotIndex = keccak256(abi.encode(key,slot)), or keccak256(abi.encode(slot,key)) for vyper
+func (m MappingValue) Key(k string) (interface{}, error) {
 	var keyByte []byte
+	var err error
 	switch m.keyTyp {
 	case UintTy:
-		keyByte = encodeUintString(k)
+		keyByte, err = encodeUintString(k)
 	case IntTy:
-		keyByte = encodeIntString(k)
+		keyByte, err = encodeIntString(k)
 	case BytesTy:
-		keyByte = encodeByteString(k)
+		keyByte, err = encodeByteString(k)
 	case StringTy:
 		keyByte = []byte(k)
+	case DynamicBytesTy:
+		keyByte, err = encodeDynamicBytesString(k)
 	case AddressTy:
-		keyByte = encodeHexString(k)
+		keyByte, err = encodeAddressString(k)
+	case BoolTy:
+		keyByte, err = encodeBoolString(k)
 	default:
-		panic("invalid key type")
-
+		err = fmt.Errorf("invalid key type %s", m.keyTyp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode mapping key %q error: %w", k, err)
 	}
 
-	slotIndex := crypto.Keccak256Hash(keyByte, m.baseSlotIndex.Bytes())
+	var slotIndex common.Hash
+	if m.vyper {
+		if m.keyTyp == StringTy || m.keyTyp == DynamicBytesTy {
+			keyByte = crypto.Keccak256(keyByte)
+		}
+		slotIndex = crypto.Keccak256Hash(m.baseSlotIndex.Bytes(), keyByte)
+	} else {
+		slotIndex = crypto.Keccak256Hash(keyByte, m.baseSlotIndex.Bytes())
+	}
 
-	reflect.ValueOf(m.valueTyp).Elem().FieldByName("SlotIndex").Set(reflect.ValueOf(slotIndex))
+	// every value type, including arrays, slices, structs and mappings, starts at the beginning of the
+	// derived slot, a fresh copy keeps the values returned for other keys untouched
+	if m.batcher != nil {
+		m.batcher.release()
+	}
+	return decodeBatched(m.valueTyp.Rebase(slotIndex), m.f, m.batcher)
 
-	return m.valueTyp.Value(m.f)
+}
 
+// Keys looks up nested mappings with one key per level,
+// e.g. Keys("0xowner", "0xspender") for mapping(address => mapping(address => uint256))
+func (m MappingValue) Keys(keys ...string) (interface{}, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no mapping key")
+	}
+	var value interface{} = m
+	for i, k := range keys {
+		mv, ok := value.(MappingValueI)
+		if !ok {
+			return nil, fmt.Errorf("value at key %q is not a mapping", keys[i-1])
+		}
+		var err error
+		value, err = mv.Key(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
 }
 
 func (m MappingValue) String() string {
 	return fmt.Sprintf("mapping{key:%s,value:%s}", m.keyTyp, m.valueTyp.Typ())
 }
 
+// MarshalJSON writes {}, the keys of a mapping are not known, use Contract.DumpJSON to list entries
+func (m MappingValue) MarshalJSON() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
+func (m MappingValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	m.f, m.batcher = f, batcher
+	return m
+}
+
 func encodeHexString(v string) []byte {
 	return common.HexToHash(v).Bytes()
 }
 
-func encodeByteString(v string) []byte {
-	if strings.Contains(v, "0x") {
-		return common.RightPadBytes(common.FromHex(v), 32)
-	} else {
-		return common.RightPadBytes([]byte(v), 32)
+// encodeByteString encodes a bytesN key left aligned, 0x prefixed keys are decoded from hex
+func encodeByteString(v string) ([]byte, error) {
+	b := []byte(v)
+	if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+		var err error
+		if b, err = hexutil.Decode("0x" + v[2:]); err != nil {
+			return nil, fmt.Errorf("invalid bytes: %v", err)
+		}
 	}
-
+	if len(b) > 32 {
+		return nil, fmt.Errorf("bytes longer than 32 bytes")
+	}
+	return common.RightPadBytes(b, 32), nil
 }
 
-func encodeUintString(v string) []byte {
-	if strings.Contains(v, "0x") {
-		return encodeHexString(v)
-	} else {
-		bn := new(big.Int)
-		bn.SetString(v, 10)
-		return common.BigToHash(bn).Bytes()
+func encodeAddressString(v string) ([]byte, error) {
+	if !common.IsHexAddress(v) {
+		return nil, fmt.Errorf("invalid address")
 	}
+	return encodeHexString(v), nil
+}
 
+// encodeBoolString encodes a true, false, 1 or 0 key as a 256 bits word holding 1 or 0
+func encodeBoolString(v string) ([]byte, error) {
+	switch v {
+	case "true", "1":
+		return common.BigToHash(common.Big1).Bytes(), nil
+	case "false", "0":
+		return common.Hash{}.Bytes(), nil
+	}
+	return nil, fmt.Errorf("invalid bool")
 }
 
-func encodeIntString(c string) []byte {
-	intVar, err := strconv.ParseInt(c, 0, 64)
-	if err != nil {
-		panic(err)
-	}
-	if intVar < 0 {
-		// invert and add 1
-		bs := common.BigToHash(big.NewInt(intVar)).Bytes()
-		ub := make([]byte, 0)
-		for _, tb := range bs {
-			ub = append(ub, ^tb)
+// encodeDynamicBytesString keys a bytes mapping by the raw content, 0x prefixed keys are decoded from hex
+func encodeDynamicBytesString(v string) ([]byte, error) {
+	if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+		b, err := hexutil.Decode("0x" + v[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes: %v", err)
 		}
-		rb := new(big.Int).SetBytes(ub)
-		return rb.Add(rb, big.NewInt(1)).Bytes()
+		return b, nil
+	}
+	return []byte(v), nil
+}
+
+// encodeUintString encodes a decimal or 0x prefixed key of any uint width as 256 bits, negative keys
+// and keys wider than 256 bits are rejected rather than truncated
+func encodeUintString(v string) ([]byte, error) {
+	uintVar := new(big.Int)
+	var ok bool
+	if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+		_, ok = uintVar.SetString(v[2:], 16)
 	} else {
-		return common.BigToHash(big.NewInt(intVar)).Bytes()
+		_, ok = uintVar.SetString(v, 10)
 	}
+	if !ok {
+		return nil, fmt.Errorf("invalid uint")
+	}
+	if uintVar.Sign() < 0 {
+		return nil, fmt.Errorf("negative uint")
+	}
+	if uintVar.BitLen() > 256 {
+		return nil, fmt.Errorf("uint out of uint256 range")
+	}
+	return common.BigToHash(uintVar).Bytes(), nil
+}
 
+// encodeIntString encodes a decimal or 0x prefixed key of any int width as a 256 bits two's complement,
+// like uint keys a leading zero does not mean octal, e.g. "-0x10" and "-16" are the same key
+func encodeIntString(c string) ([]byte, error) {
+	digits := strings.TrimPrefix(c, "-")
+	intVar := new(big.Int)
+	var ok bool
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		_, ok = intVar.SetString(digits[2:], 16)
+	} else {
+		_, ok = intVar.SetString(digits, 10)
+	}
+	if !ok || intVar.Sign() < 0 || (len(digits) < len(c) && strings.HasPrefix(digits, "+")) {
+		return nil, fmt.Errorf("invalid int")
+	}
+	if len(digits) < len(c) {
+		intVar.Neg(intVar)
+	}
+	if intVar.BitLen() > 255 && !(intVar.Sign() < 0 && intVar.Cmp(minInt256) == 0) {
+		return nil, fmt.Errorf("int out of int256 range")
+	}
+	if intVar.Sign() < 0 {
+		// add 2^256 to get the two's complement
+		intVar.Add(intVar, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return common.BigToHash(intVar).Bytes(), nil
 }
@@ -0,0 +1,222 @@
+package storagescan
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEncodeUintString(t *testing.T) {
+	tests := []struct {
+		key  string
+		want common.Hash
+		err  string
+	}{
+		{key: "1", want: common.BigToHash(common.Big1)},
+		{key: "0x10", want: common.HexToHash("0x10")},
+		{key: "0X10", want: common.HexToHash("0x10")},
+		{key: "010", want: common.HexToHash("0x0a")},
+		{key: "115792089237316195423570985008687907853269984665640564039457584007913129639935", want: common.HexToHash("0x" + strings.Repeat("ff", 32))},
+		{key: "-1", err: "negative uint"},
+		{key: "0x-1", err: "negative uint"},
+		{key: "115792089237316195423570985008687907853269984665640564039457584007913129639936", err: "uint out of uint256 range"},
+		{key: "0x1" + strings.Repeat("00", 32), err: "uint out of uint256 range"},
+		{key: "0xzz", err: "invalid uint"},
+		{key: "abc", err: "invalid uint"},
+		{key: "1_0", err: "invalid uint"},
+		{key: "", err: "invalid uint"},
+	}
+	for _, tt := range tests {
+		got, err := encodeUintString(tt.key)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("encodeUintString(%q) error = %v, want %s", tt.key, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || common.BytesToHash(got) != tt.want {
+			t.Errorf("encodeUintString(%q) = %x, %v, want %x", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeIntString(t *testing.T) {
+	maxInt256 := "57896044618658097711785492504343953926634992332820282019728792003956564819967"
+	tests := []struct {
+		key  string
+		want common.Hash
+		err  string
+	}{
+		{key: "10", want: common.HexToHash("0x0a")},
+		{key: "010", want: common.HexToHash("0x0a")},
+		{key: "0x10", want: common.HexToHash("0x10")},
+		{key: "-0X10", want: common.HexToHash("0x" + strings.Repeat("ff", 31) + "f0")},
+		{key: "-1", want: common.HexToHash("0x" + strings.Repeat("ff", 32))},
+		{key: maxInt256, want: common.HexToHash("0x7f" + strings.Repeat("ff", 31))},
+		{key: "-" + "57896044618658097711785492504343953926634992332820282019728792003956564819968", want: common.HexToHash("0x80" + strings.Repeat("00", 31))},
+		{key: "57896044618658097711785492504343953926634992332820282019728792003956564819968", err: "int out of int256 range"},
+		{key: "-57896044618658097711785492504343953926634992332820282019728792003956564819969", err: "int out of int256 range"},
+		{key: "1_0", err: "invalid int"},
+		{key: "--1", err: "invalid int"},
+		{key: "-+1", err: "invalid int"},
+		{key: "0xzz", err: "invalid int"},
+		{key: "", err: "invalid int"},
+	}
+	for _, tt := range tests {
+		got, err := encodeIntString(tt.key)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("encodeIntString(%q) = %x, %v, want %s", tt.key, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || common.BytesToHash(got) != tt.want {
+			t.Errorf("encodeIntString(%q) = %x, %v, want %x", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeByteString(t *testing.T) {
+	tests := []struct {
+		key  string
+		want common.Hash
+		err  bool
+	}{
+		{key: "0xdead", want: common.HexToHash("0xdead" + strings.Repeat("00", 30))},
+		{key: "ab", want: common.HexToHash("0x6162" + strings.Repeat("00", 30))},
+		{key: "0x" + strings.Repeat("ff", 32), want: common.HexToHash("0x" + strings.Repeat("ff", 32))},
+		{key: "0x" + strings.Repeat("ff", 33), err: true},
+		{key: "0xzz", err: true},
+		{key: strings.Repeat("a", 33), err: true},
+	}
+	for _, tt := range tests {
+		got, err := encodeByteString(tt.key)
+		if tt.err {
+			if err == nil {
+				t.Errorf("encodeByteString(%q) = %x, want error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || common.BytesToHash(got) != tt.want {
+			t.Errorf("encodeByteString(%q) = %x, %v, want %x", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeDynamicBytesString(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+		err  bool
+	}{
+		{key: "0xdead", want: "dead"},
+		{key: "0XDEAD", want: "dead"},
+		{key: "0x", want: ""},
+		{key: "ab", want: "6162"},
+		{key: "0x" + strings.Repeat("ff", 40), want: strings.Repeat("ff", 40)},
+		{key: "0xzz12", err: true},
+		{key: "0xabc", err: true},
+	}
+	for _, tt := range tests {
+		got, err := encodeDynamicBytesString(tt.key)
+		if tt.err {
+			if err == nil {
+				t.Errorf("encodeDynamicBytesString(%q) = %x, want error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || common.Bytes2Hex(got) != tt.want {
+			t.Errorf("encodeDynamicBytesString(%q) = %x, %v, want %s", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestMappingKeyRejectsNegativeUint(t *testing.T) {
+	m := MappingValue{keyTyp: UintTy, valueTyp: &SolidityUint{Length: 256}, f: func(common.Hash) ([]byte, error) {
+		t.Fatal("storage read for an invalid key")
+		return nil, nil
+	}}
+	if _, err := m.Key("-1"); err == nil || !strings.Contains(err.Error(), "negative uint") {
+		t.Fatalf("Key(-1) error = %v, want negative uint", err)
+	}
+}
+
+// mapping(address => uint256[]) lists; mapping(uint256 => Entity[]) entities;
+// mapping(uint256 => uint8[3]) fixed; mapping(string => Entity) byName;
+// struct Entity { uint128 id; uint64 kind; string name; }
+const compositeMappingLayout = `{"storage":[
+{"label":"lists","offset":0,"slot":"0","type":"t_mapping(t_address,t_array(t_uint256)dyn_storage)"},
+{"label":"entities","offset":0,"slot":"1","type":"t_mapping(t_uint256,t_array(t_struct(Entity)9_storage)dyn_storage)"},
+{"label":"fixed","offset":0,"slot":"2","type":"t_mapping(t_uint256,t_array(t_uint8)3_storage)"},
+{"label":"byName","offset":0,"slot":"3","type":"t_mapping(t_string_memory_ptr,t_struct(Entity)9_storage)"}],
+"types":{
+"t_address":{"encoding":"inplace","label":"address","numberOfBytes":"20"},
+"t_uint8":{"encoding":"inplace","label":"uint8","numberOfBytes":"1"},
+"t_uint64":{"encoding":"inplace","label":"uint64","numberOfBytes":"8"},
+"t_uint128":{"encoding":"inplace","label":"uint128","numberOfBytes":"16"},
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_string_memory_ptr":{"encoding":"bytes","label":"string","numberOfBytes":"32"},
+"t_string_storage":{"encoding":"bytes","label":"string","numberOfBytes":"32"},
+"t_array(t_uint256)dyn_storage":{"base":"t_uint256","encoding":"dynamic_array","label":"uint256[]","numberOfBytes":"32"},
+"t_array(t_uint8)3_storage":{"base":"t_uint8","encoding":"inplace","label":"uint8[3]","numberOfBytes":"32"},
+"t_array(t_struct(Entity)9_storage)dyn_storage":{"base":"t_struct(Entity)9_storage","encoding":"dynamic_array","label":"struct Entity[]","numberOfBytes":"32"},
+"t_struct(Entity)9_storage":{"encoding":"inplace","label":"struct Entity","numberOfBytes":"64","members":[
+{"label":"id","offset":0,"slot":"0","type":"t_uint128"},
+{"label":"kind","offset":16,"slot":"0","type":"t_uint64"},
+{"label":"name","offset":0,"slot":"1","type":"t_string_storage"}]},
+"t_mapping(t_address,t_array(t_uint256)dyn_storage)":{"encoding":"mapping","key":"t_address","label":"mapping(address => uint256[])","numberOfBytes":"32","value":"t_array(t_uint256)dyn_storage"},
+"t_mapping(t_uint256,t_array(t_struct(Entity)9_storage)dyn_storage)":{"encoding":"mapping","key":"t_uint256","label":"mapping(uint256 => struct Entity[])","numberOfBytes":"32","value":"t_array(t_struct(Entity)9_storage)dyn_storage"},
+"t_mapping(t_uint256,t_array(t_uint8)3_storage)":{"encoding":"mapping","key":"t_uint256","label":"mapping(uint256 => uint8[3])","numberOfBytes":"32","value":"t_array(t_uint8)3_storage"},
+"t_mapping(t_string_memory_ptr,t_struct(Entity)9_storage)":{"encoding":"mapping","key":"t_string_memory_ptr","label":"mapping(string => struct Entity)","numberOfBytes":"32","value":"t_struct(Entity)9_storage"}}}`
+
+// entityWord packs the id and kind members of an Entity in its first slot
+func entityWord(id, kind uint64) common.Hash {
+	word := new(big.Int).Lsh(new(big.Int).SetUint64(kind), 128)
+	return common.BigToHash(word.Or(word, new(big.Int).SetUint64(id)))
+}
+
+func TestCompositeMappingValues(t *testing.T) {
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	st := testStorage{}
+	// lists[owner] = [1, 2, 3]
+	list := crypto.Keccak256Hash(common.LeftPadBytes(owner.Bytes(), 32), slotOf(0).Bytes())
+	st[list] = slotOf(3)
+	for i := uint64(0); i < 3; i++ {
+		st[dataSlot(list, i)] = slotOf(i + 1)
+	}
+	// entities[5] = [{7, 1, "seven"}, {8, 2, a name longer than a slot}]
+	entities := crypto.Keccak256Hash(slotOf(5).Bytes(), slotOf(1).Bytes())
+	st[entities] = slotOf(2)
+	st[dataSlot(entities, 0)] = entityWord(7, 1)
+	st.putString(dataSlot(entities, 1), "seven")
+	st[dataSlot(entities, 2)] = entityWord(8, 2)
+	st.putString(dataSlot(entities, 3), strings.Repeat("eight", 10))
+	// fixed[2] = [4, 5, 6] packed in one slot
+	st[crypto.Keccak256Hash(slotOf(2).Bytes(), slotOf(2).Bytes())] = common.HexToHash("0x060504")
+	// byName["bob"] = {9, 3, "bob"}
+	bob := crypto.Keccak256Hash([]byte("bob"), slotOf(3).Bytes())
+	st[bob] = entityWord(9, 3)
+	st.putString(rebaseSlot(bob, slotOf(1)), "bob")
+
+	checkQueries(t, compositeMappingLayout, st, []queryTest{
+		{path: "lists[" + owner.Hex() + "]", want: "[1 2 3]"},
+		{path: "lists[" + owner.Hex() + "][2]", want: "3"},
+		{path: "lists[0x00000000000000000000000000000000000000bb]", want: "[]"},
+		{path: "entities[5][0].id", want: "7"},
+		{path: "entities[5][0].kind", want: "1"},
+		{path: "entities[5][0].name", want: "seven"},
+		{path: "entities[5][1].id", want: "8"},
+		{path: "entities[5][1].kind", want: "2"},
+		{path: "entities[5][1].name", want: strings.Repeat("eight", 10)},
+		{path: "entities[6]", want: "[]"},
+		{path: "fixed[2]", want: "[4 5 6]"},
+		{path: "fixed[2][1]", want: "5"},
+		{path: "fixed[3]", want: "[0 0 0]"},
+		{path: "byName[bob].id", want: "9"},
+		{path: "byName[bob].kind", want: "3"},
+		{path: "byName[bob].name", want: "bob"},
+	})
+}
@@ -3,14 +3,17 @@ package storagescan
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
-	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type StorageLayout struct {
@@ -37,6 +40,9 @@ type StorageType struct {
 	NumberOfBytes string    `json:"numberOfBytes"`
 }
 
+// ErrVariableNotFound is returned when a variable name is not part of the parsed storage layout
+var ErrVariableNotFound = errors.New("variable not found")
+
 type Contract struct {
 	Address common.Address `json:"address"`
 
@@ -45,6 +51,19 @@ type Contract struct {
 	Variables map[string]Variable `json:"variables"`
 
 	StorageLayout StorageLayout `json:"storage_layout"`
+
+	// Lenient makes parsing skip the variables whose type cannot be decoded and record them in Skipped,
+	// by default parsing fails with a *LayoutError listing them
+	Lenient bool `json:"lenient"`
+
+	// Skipped holds the variables left out by a lenient parse
+	Skipped []LayoutIssue `json:"skipped,omitempty"`
+
+	// Block pins every read to one block, nil reads the latest state
+	Block *rpc.BlockNumberOrHash `json:"block,omitempty"`
+
+	// reader is shared by copies of the contract and reused for every query
+	reader StorageReader
 }
 
 type VariableDesc struct {
@@ -53,42 +72,183 @@ type VariableDesc struct {
 }
 
 func NewContract(address common.Address, rpcNode string) *Contract {
+	c := NewContractWithReader(address, NewRPCStorageReader(rpcNode))
+	c.RPCNode = rpcNode
+	return c
+}
+
+// NewContractWithClient reads storage through client, which stays owned by the caller
+func NewContractWithClient(address common.Address, client *ethclient.Client) *Contract {
+	return NewContractWithReader(address, NewStorageReaderFromClient(client))
+}
+
+// NewContractWithRPCClient reads storage through client, which stays owned by the caller
+func NewContractWithRPCClient(address common.Address, client *rpc.Client) *Contract {
+	return NewContractWithReader(address, NewStorageReaderFromRPCClient(client))
+}
+
+// NewContractFromLayout returns a contract reading storage through reader with the variables of the
+// solc storage layout layOutJson
+func NewContractFromLayout(address common.Address, reader StorageReader, layOutJson string) (*Contract, error) {
+	c := NewContractWithReader(address, reader)
+	if err := c.ParseByStorageLayout(layOutJson); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func NewContractWithReader(address common.Address, reader StorageReader) *Contract {
 	return &Contract{
 		Address:   address,
-		RPCNode:   rpcNode,
 		Variables: map[string]Variable{},
+		reader:    reader,
 	}
 }
 
-func (c Contract) ParseByStorageLayout(layOutJson string) (err error) {
-	err = json.Unmarshal([]byte(layOutJson), &c.StorageLayout)
-	if err != nil {
-		err = fmt.Errorf("parse storage layout error: %v", err)
-		return
+// AtBlock returns a view of the contract whose reads are pinned to the block number, nil means latest
+// the view shares variables and the storage reader with c
+func (c *Contract) AtBlock(number *big.Int) *Contract {
+	view := *c
+	view.Block = BlockNumber(number)
+	return &view
+}
+
+// AtBlockHash returns a view of the contract whose reads are pinned to the block hash
+func (c *Contract) AtBlockHash(hash common.Hash) *Contract {
+	view := *c
+	view.Block = BlockHash(hash)
+	return &view
+}
+
+// WithCache returns a view of the contract whose reads go through cache, the cache can be shared
+// between contracts and blocks since its entries are keyed by address and block
+func (c *Contract) WithCache(cache *SlotCache) *Contract {
+	view := *c
+	if view.reader == nil {
+		view.reader = NewRPCStorageReader(view.RPCNode)
+	}
+	view.reader = cache.Reader(view.reader)
+	return &view
+}
+
+// WithRecorder returns a view of the contract recording every slot it reads into recorder
+func (c *Contract) WithRecorder(recorder *SnapshotRecorder) *Contract {
+	view := *c
+	if view.reader == nil {
+		view.reader = NewRPCStorageReader(view.RPCNode)
+	}
+	view.reader = recorder.Reader(view.reader)
+	return &view
+}
+
+// Close releases the storage reader of the contract
+func (c *Contract) Close() {
+	if c.reader != nil {
+		c.reader.Close()
+	}
+}
+
+// ParseByStorageLayout parses the variables of a solc storage layout, the variables of a previous parse
+// are dropped, use MergeStorageLayout to add the variables of another layout
+func (c *Contract) ParseByStorageLayout(layOutJson string) error {
+	var layout StorageLayout
+	if err := json.Unmarshal([]byte(layOutJson), &layout); err != nil {
+		return fmt.Errorf("parse storage layout error: %v", err)
+	}
+	return c.mergeStorageLayout(layout, false)
+}
+
+// MergeStorageLayout adds the variables of another solc storage layout to the parsed ones, e.g. the
+// layout of a proxy and the one of its implementation, a variable already parsed is replaced by the
+// variable of the same name
+func (c *Contract) MergeStorageLayout(layOutJson string) error {
+	var layout StorageLayout
+	if err := json.Unmarshal([]byte(layOutJson), &layout); err != nil {
+		return fmt.Errorf("parse storage layout error: %v", err)
+	}
+	return c.mergeStorageLayout(layout, true)
+}
+
+// mergeStorageLayout adds the variables of layout to the parsed ones when keep is set, or else replaces them.
+// the layout and variables maps are replaced rather than modified, views of the contract share them
+func (c *Contract) mergeStorageLayout(layout StorageLayout, keep bool) error {
+	prev, prevVariables, prevSkipped := c.StorageLayout, c.Variables, c.Skipped
+	if !keep {
+		prev, prevVariables, prevSkipped = StorageLayout{}, nil, nil
+	}
+	merged := StorageLayout{
+		Types: make(map[string]StorageType, len(prev.Types)+len(layout.Types)),
+	}
+	for id, t := range prev.Types {
+		merged.Types[id] = t
+	}
+	for id, t := range layout.Types {
+		merged.Types[id] = t
 	}
-	for _, s := range c.StorageLayout.Storage {
-		variableName := s.Label
-		offset := s.Offset * 8
-		sb := new(big.Int)
-		sb.SetString(s.Slot, 10)
-		slotIndex := common.BigToHash(sb)
 
-		v := c.getVariableByVariableType(s.Type)
-		reflect.ValueOf(v).Elem().FieldByName("SlotIndex").Set(reflect.ValueOf(slotIndex))
-		if v.Len() < 256 && offset != 0 {
-			reflect.ValueOf(v).Elem().FieldByName("Offset").Set(reflect.ValueOf(uint(offset)))
+	variables := make(map[string]Variable, len(prevVariables)+len(layout.Storage))
+	for name, v := range prevVariables {
+		variables[name] = v
+	}
+	parsed, issues := merged.buildVariables(layout.Storage)
+	if len(issues) > 0 && !c.Lenient {
+		return &LayoutError{Issues: issues}
+	}
+	replaced := make(map[string]bool, len(layout.Storage))
+	for _, s := range layout.Storage {
+		delete(variables, s.Label)
+		replaced[s.Label] = true
+	}
+	for name, v := range parsed {
+		variables[name] = v
+	}
+	var skipped []LayoutIssue
+	for _, issue := range prevSkipped {
+		if !replaced[issue.Label] {
+			skipped = append(skipped, issue)
 		}
-		c.Variables[variableName] = v
+	}
+	skipped = append(skipped, issues...)
+	for _, s := range prev.Storage {
+		if !replaced[s.Label] {
+			merged.Storage = append(merged.Storage, s)
+		}
+	}
+	merged.Storage = append(merged.Storage, layout.Storage...)
 
+	c.StorageLayout, c.Variables, c.Skipped = merged, variables, skipped
+	return nil
+}
+
+func (c *Contract) GetVariableValue(name string) (interface{}, error) {
+	v, ok := c.Variables[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVariableNotFound, name)
+	}
+	ctx := context.Background()
+	f := c.storageAtFunc(ctx)
+	br, ok := c.reader.(BatchStorageReader)
+	if !ok {
+		return v.Value(f)
 	}
-	return
+	// values are lazy, their accessors batch the reads of what they return
+	b := newSlotBatcher(f, GenReaderBatchStorageValueFunc(ctx, br, c.Address, c.Block))
+	return decodeBatched(v, b.storageAt, b)
 }
 
-func (c Contract) GetVariableValue(name string) interface{} {
-	return c.Variables[name].Value(GenGetStorageValueFunc(context.Background(), c.RPCNode, c.Address))
+func (c *Contract) storageAtFunc(ctx context.Context) GetValueStorageAtFunc {
+	if c.reader == nil {
+		// contract built without a constructor, there is no reader to keep the connection
+		return func(s common.Hash) ([]byte, error) {
+			reader := NewRPCStorageReader(c.RPCNode)
+			defer reader.Close()
+			return reader.StorageAt(ctx, c.Address, s, c.Block)
+		}
+	}
+	return GenReaderStorageValueFunc(ctx, c.reader, c.Address, c.Block)
 }
 
-func (c Contract) GetAllVariables() []VariableDesc {
+func (c *Contract) GetAllVariables() []VariableDesc {
 	var variables []VariableDesc
 	for k, v := range c.Variables {
 		variables = append(variables, VariableDesc{
@@ -103,104 +263,237 @@ func (c Contract) GetAllVariables() []VariableDesc {
 	return variables
 }
 
-func (c Contract) getVariableByVariableType(vt string) Variable {
-	if vtForm, ok := c.StorageLayout.Types[vt]; ok {
-		switch vtForm.Encoding {
-		case "bytes":
-			// string
-			return &SolidityString{}
-		case "inplace":
-			if vtForm.Base != "" {
-				// array
-				arrayRegexp := regexp.MustCompile(`(.*)\[(.*)\]`)
-				arrayMatch := arrayRegexp.FindStringSubmatch(vtForm.Label)
-				arraySize, _ := strconv.ParseUint(arrayMatch[2], 10, 64)
-
-				return &SolidityArray{
-					UnitLength: arraySize,
-					UnitTyp:    c.getVariableByVariableType(vtForm.Base),
-				}
+var (
+	arrayLabelRegexp      = regexp.MustCompile(`\[(\d+)\]$`)
+	elementaryLabelRegexp = regexp.MustCompile(`^(bytes|uint|int)(\d+)$`)
+)
+
+// getVariableByVariableType builds the variable of type vt located at slot, offset is the bit offset of a
+// value packed with others, nested element and mapping value types are built at slot zero.
+// parents holds the types being built, only a type holding itself through a static array or a struct member
+// is rejected, mapping values and dynamic array elements are resolved on access by a layoutTypeRef
+func (l StorageLayout) getVariableByVariableType(vt string, slot common.Hash, offset uint, parents map[string]bool) (Variable, error) {
+	vtForm, ok := l.Types[vt]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found in layout types", vt)
+	}
+	if parents[vt] {
+		return nil, fmt.Errorf("type %s (%s) holds itself without a mapping or a dynamic array", vt, vtForm.Label)
+	}
+	parents[vt] = true
+	defer delete(parents, vt)
+
+	switch vtForm.Encoding {
+	case "bytes":
+		if vtForm.Label == "bytes" {
+			return &SolidityDynamicBytes{SlotIndex: slot}, nil
+		}
+		// string
+		return &SolidityString{SlotIndex: slot}, nil
+	case "inplace":
+		if vtForm.Base != "" {
+			// array
+			arrayMatch := arrayLabelRegexp.FindStringSubmatch(vtForm.Label)
+			if arrayMatch == nil {
+				return nil, fmt.Errorf("invalid array type %s (%s), missing length", vt, vtForm.Label)
 			}
-			// bytes1,uint256,int1
-			regExp := regexp.MustCompile(`(bytes|uint|int)(\d+)`)
-			isMatch := regExp.MatchString(vtForm.Label)
-			if isMatch {
-				// bytes1,uint256,int1
-				subMatch := regExp.FindStringSubmatch(vtForm.Label)
-				length, _ := strconv.ParseUint(subMatch[2], 10, 64)
-				switch subMatch[1] {
-				case "bytes":
-					return &SolidityBytes{
-						Length: uint(length * 8),
-					}
-				case "uint":
-					return &SolidityUint{
-						Length: uint(length),
-					}
-				case "int":
-					return &SolidityInt{
-						Length: uint(length),
-					}
-				}
-			} else {
-				// bool,address,struct
-				if vtForm.Label == "address" {
-					return &SolidityAddress{}
-				}
+			arraySize, err := strconv.ParseUint(arrayMatch[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid array type %s (%s): %v", vt, vtForm.Label, err)
+			}
+			unitTyp, err := l.getVariableByVariableType(vtForm.Base, common.Hash{}, 0, parents)
+			if err != nil {
+				return nil, err
+			}
+			return &SolidityArray{
+				SlotIndex:  slot,
+				UnitLength: arraySize,
+				UnitTyp:    unitTyp,
+			}, nil
+		}
+		// bytes1,uint256,int8
+		if subMatch := elementaryLabelRegexp.FindStringSubmatch(vtForm.Label); subMatch != nil {
+			length, _ := strconv.ParseUint(subMatch[2], 10, 64)
+			switch {
+			case subMatch[1] == "bytes" && length >= 1 && length <= 32:
+				return &SolidityBytes{
+					SlotIndex: slot,
+					Length:    uint(length * 8),
+					Offset:    offset,
+				}, nil
+			case subMatch[1] == "uint" && length >= 8 && length <= 256 && length%8 == 0:
+				return &SolidityUint{
+					SlotIndex: slot,
+					Length:    uint(length),
+					Offset:    offset,
+				}, nil
+			case subMatch[1] == "int" && length >= 8 && length <= 256 && length%8 == 0:
+				return &SolidityInt{
+					SlotIndex: slot,
+					Length:    uint(length),
+					Offset:    offset,
+				}, nil
+			}
+			return nil, fmt.Errorf("invalid type %s (%s), bad width", vt, vtForm.Label)
+		}
+		// bool,address,struct
+		if vtForm.Label == "address" || vtForm.Label == "address payable" {
+			return &SolidityAddress{SlotIndex: slot, Offset: offset}, nil
+		}
 
-				if vtForm.Label == "bool" {
-					return &SolidityBool{}
-				}
-				// enum
-				if strings.HasPrefix(vtForm.Label, "enum") {
-					bytesLen, _ := strconv.ParseUint(vtForm.NumberOfBytes, 10, 64)
-					return &SolidityUint{
-						Length: uint(bytesLen) * 8,
-					}
+		if vtForm.Label == "bool" {
+			return &SolidityBool{SlotIndex: slot, Offset: offset}, nil
+		}
+		// enum
+		if strings.HasPrefix(vtForm.Label, "enum") {
+			bytesLen, _ := strconv.ParseUint(vtForm.NumberOfBytes, 10, 64)
+			if bytesLen == 0 || bytesLen > 32 {
+				return nil, fmt.Errorf("invalid enum type %s (%s), bad number of bytes %q", vt, vtForm.Label, vtForm.NumberOfBytes)
+			}
+			return &SolidityUint{
+				SlotIndex: slot,
+				Length:    uint(bytesLen) * 8,
+				Offset:    offset,
+			}, nil
+		}
+		// contract
+		if strings.HasPrefix(vtForm.Label, "contract") {
+			return &SolidityAddress{SlotIndex: slot, Offset: offset}, nil
+		}
+
+		if strings.HasPrefix(vtForm.Label, "struct") {
+			filedValueMap := make(map[string]Variable)
+			for _, m := range vtForm.Members {
+				sb, ok := new(big.Int).SetString(m.Slot, 10)
+				if !ok {
+					return nil, fmt.Errorf("member %s of %s has invalid slot %q", m.Label, vt, m.Slot)
 				}
-				// contract
-				if strings.HasPrefix(vtForm.Label, "contract") {
-					return &SolidityAddress{}
+				// members are located relative to the start of the struct
+				member, err := l.getVariableByVariableType(m.Type, common.BigToHash(sb), uint(m.Offset*8), parents)
+				if err != nil {
+					return nil, fmt.Errorf("member %s of %s: %w", m.Label, vt, err)
 				}
+				filedValueMap[m.Label] = member
+			}
+
+			numberOfBytes, _ := strconv.ParseUint(vtForm.NumberOfBytes, 10, 64)
+			return &SolidityStruct{
+				SlotIndex:     slot,
+				FiledValueMap: filedValueMap,
+				NumberOfBytes: numberOfBytes,
+			}, nil
+		}
+		// fixed point numbers, function types and user defined value types
+		return nil, fmt.Errorf("unsupported type %s (%s)", vt, vtForm.Label)
+
+	case "mapping":
+		keyTyp, err := l.getVariableByVariableType(vtForm.Key, common.Hash{}, 0, parents)
+		if err != nil {
+			return nil, err
+		}
+		switch keyTyp.Typ() {
+		case StructTy, ArrayTy, SliceTy, MappingTy:
+			return nil, fmt.Errorf("invalid mapping type %s (%s), bad key type %s", vt, vtForm.Label, vtForm.Key)
+		}
+		valueTyp, err := l.typeRef(vtForm.Value, parents)
+		if err != nil {
+			return nil, err
+		}
+		return &SolidityMapping{
+			SlotIndex: slot,
+			KeyTyp:    keyTyp.Typ(),
+			ValueTyp:  valueTyp,
+		}, nil
+
+	case "dynamic_array":
+		unitTyp, err := l.typeRef(vtForm.Base, parents)
+		if err != nil {
+			return nil, err
+		}
+		return &SoliditySlice{
+			SlotIndex: slot,
+			UnitTyp:   unitTyp,
+		}, nil
+	}
+	return nil, fmt.Errorf("type %s (%s) has unsupported encoding %q", vt, vtForm.Label, vtForm.Encoding)
+}
 
-				if strings.HasPrefix(vtForm.Label, "struct") {
-					filedValueMap := make(map[string]Variable)
-					for _, m := range vtForm.Members {
-						offset := m.Offset * 8
-						sb := new(big.Int)
-						sb.SetString(m.Slot, 10)
-						slotIndex := common.BigToHash(sb)
+// typeRef returns the lazily built variable of type vt at slot zero, vt is built now to report the types
+// that cannot be decoded, unless it is one of the parents, e.g. struct Node { Node[] children; }
+func (l StorageLayout) typeRef(vt string, parents map[string]bool) (Variable, error) {
+	r := &layoutTypeRef{TypeID: vt, types: l.Types}
+	if !parents[vt] {
+		v, err := l.getVariableByVariableType(vt, common.Hash{}, 0, parents)
+		if err != nil {
+			return nil, err
+		}
+		r.once.Do(func() { r.v = v })
+	}
+	return r, nil
+}
 
-						mv := c.getVariableByVariableType(m.Type)
+// layoutTypeRef is a mapping value or dynamic array element type built from the layout types on first use,
+// the elements of a type holding itself live at hashed slots so it is only built as deep as it is read
+type layoutTypeRef struct {
+	TypeID string `json:"type_id"`
 
-						reflect.ValueOf(mv).Elem().FieldByName("SlotIndex").Set(reflect.ValueOf(slotIndex))
-						if mv.Len() < 256 && offset != 0 {
-							reflect.ValueOf(mv).Elem().FieldByName("Offset").Set(reflect.ValueOf(uint(offset)))
-						}
-						filedValueMap[m.Label] = mv
-					}
+	types map[string]StorageType
 
-					return &SolidityStruct{
-						FiledValueMap: filedValueMap,
-					}
+	once sync.Once
+	v    Variable
+	err  error
+}
 
-				}
+// variable builds the referenced type at slot zero, a type built by typeRef was already checked with its
+// parents, so an error here means the layout types were modified after parsing
+func (r *layoutTypeRef) variable() (Variable, error) {
+	r.once.Do(func() {
+		r.v, r.err = StorageLayout{Types: r.types}.getVariableByVariableType(r.TypeID, common.Hash{}, 0, map[string]bool{})
+	})
+	return r.v, r.err
+}
 
-			}
+// resolveVariable returns the variable v refers to, or v itself
+func resolveVariable(v Variable) (Variable, error) {
+	if r, ok := v.(*layoutTypeRef); ok {
+		return r.variable()
+	}
+	return v, nil
+}
 
-		case "mapping":
-			return &SolidityMapping{
-				KeyTyp:   c.getVariableByVariableType(vtForm.Key).Typ(),
-				ValueTyp: c.getVariableByVariableType(vtForm.Value),
-			}
+func (r *layoutTypeRef) Typ() SolidityTyp {
+	v, err := r.variable()
+	if err != nil {
+		// not a valid SolidityTyp, its String is unknown
+		return SolidityTyp(255)
+	}
+	return v.Typ()
+}
 
-		case "dynamic_array":
-			return &SoliditySlice{
-				UnitTyp: c.getVariableByVariableType(vtForm.Base),
-			}
+func (r *layoutTypeRef) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := r.variable()
+	if err != nil {
+		return nil, err
+	}
+	return v.Value(f)
+}
 
-		}
+func (r *layoutTypeRef) Len() uint {
+	v, err := r.variable()
+	if err != nil {
+		return 0
+	}
+	return v.Len()
+}
+
+func (r *layoutTypeRef) Slot() common.Hash {
+	return common.Hash{}
+}
 
+func (r *layoutTypeRef) Rebase(base common.Hash) Variable {
+	v, err := r.variable()
+	if err != nil {
+		return r
 	}
-	return nil
+	return v.Rebase(base)
 }
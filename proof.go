@@ -0,0 +1,179 @@
+package storagescan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"sync"
+)
+
+// ErrProofVerification is returned when a storage value can not be proven against the trusted state root
+var ErrProofVerification = errors.New("storage proof verification failed")
+
+// ProofStorageReader reads storage with eth_getProof and verifies every slot against a trusted state root,
+// the rpc provider itself does not need to be trusted
+type ProofStorageReader struct {
+	// BatchSize is the maximum number of slots proven by one eth_getProof call, zero means defaultBatchSize
+	BatchSize int
+
+	client *rpc.Client
+
+	stateRoot common.Hash
+
+	// block is read when the caller does not pin one, it is the block of the trusted header if any
+	block *rpc.BlockNumberOrHash
+
+	mu sync.Mutex
+
+	closed bool
+}
+
+// NewProofStorageReader verifies every slot against stateRoot, reads must be pinned to the block of
+// that root, other blocks fail verification
+func NewProofStorageReader(client *rpc.Client, stateRoot common.Hash) *ProofStorageReader {
+	return &ProofStorageReader{
+		client:    client,
+		stateRoot: stateRoot,
+	}
+}
+
+// NewProofStorageReaderFromHeader verifies every slot against the state root of a trusted header,
+// unpinned reads are served from that header's block
+func NewProofStorageReaderFromHeader(client *rpc.Client, header *types.Header) *ProofStorageReader {
+	return &ProofStorageReader{
+		client:    client,
+		stateRoot: header.Root,
+		block:     BlockHash(header.Hash()),
+	}
+}
+
+type storageProof struct {
+	Key   string          `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+type accountProof struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []storageProof  `json:"storageProof"`
+}
+
+func (r *ProofStorageReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	values, err := r.BatchStorageAt(ctx, account, []common.Hash{slot}, block)
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// BatchStorageAt proves all slots of one chunk with a single eth_getProof call
+func (r *ProofStorageReader) BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return nil, ErrReaderClosed
+	}
+	if block == nil {
+		block = r.block
+	}
+
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	values := make([][]byte, 0, len(slots))
+	for begin := 0; begin < len(slots); begin += batchSize {
+		end := begin + batchSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		var res accountProof
+		err := r.client.CallContext(ctx, &res, "eth_getProof", account, slots[begin:end], blockArg(block))
+		if err != nil {
+			return nil, fmt.Errorf("get storage proof error: %w", err)
+		}
+		proven, err := verifyStorageProof(r.stateRoot, account, slots[begin:end], &res)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, proven...)
+	}
+	return values, nil
+}
+
+// Close marks the reader closed, the rpc client stays owned by the caller
+func (r *ProofStorageReader) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+// verifyStorageProof checks the account proof against stateRoot, then every storage proof against the
+// proven storage root, it returns the proven values as 32 bytes words
+func verifyStorageProof(stateRoot common.Hash, account common.Address, slots []common.Hash, res *accountProof) ([][]byte, error) {
+	accountRLP, err := trie.VerifyProof(stateRoot, crypto.Keccak256(account.Bytes()), proofDB(res.AccountProof))
+	if err != nil {
+		return nil, fmt.Errorf("%w: account %s: %v", ErrProofVerification, account.Hex(), err)
+	}
+	storageRoot := types.EmptyRootHash
+	if accountRLP != nil {
+		var sa types.StateAccount
+		if err = rlp.DecodeBytes(accountRLP, &sa); err != nil {
+			return nil, fmt.Errorf("%w: account %s: %v", ErrProofVerification, account.Hex(), err)
+		}
+		storageRoot = sa.Root
+	}
+	if res.StorageHash != storageRoot {
+		return nil, fmt.Errorf("%w: account %s: storage root %s does not match proven root %s",
+			ErrProofVerification, account.Hex(), res.StorageHash.Hex(), storageRoot.Hex())
+	}
+	if len(res.StorageProof) != len(slots) {
+		return nil, fmt.Errorf("%w: got %d storage proofs for %d slots", ErrProofVerification, len(res.StorageProof), len(slots))
+	}
+
+	values := make([][]byte, len(slots))
+	for i, slot := range slots {
+		sp := res.StorageProof[i]
+		var proven []byte
+		if storageRoot != types.EmptyRootHash {
+			valueRLP, err := trie.VerifyProof(storageRoot, crypto.Keccak256(slot.Bytes()), proofDB(sp.Proof))
+			if err != nil {
+				return nil, fmt.Errorf("%w: slot %s: %v", ErrProofVerification, slot.Hex(), err)
+			}
+			if valueRLP != nil {
+				if _, proven, _, err = rlp.Split(valueRLP); err != nil {
+					return nil, fmt.Errorf("%w: slot %s: %v", ErrProofVerification, slot.Hex(), err)
+				}
+			}
+		}
+		word := common.LeftPadBytes(proven, 32)
+		if sp.Value == nil || !bytes.Equal(common.BigToHash(sp.Value.ToInt()).Bytes(), word) {
+			return nil, fmt.Errorf("%w: slot %s: reported value does not match the proof", ErrProofVerification, slot.Hex())
+		}
+		values[i] = word
+	}
+	return values, nil
+}
+
+func proofDB(proof []hexutil.Bytes) *memorydb.Database {
+	db := memorydb.New()
+	for _, node := range proof {
+		_ = db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
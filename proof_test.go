@@ -0,0 +1,175 @@
+package storagescan
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// proofService answers eth_getProof from a state built with go-ethereum, the same way a node does,
+// tamper edits the response before it is sent
+type proofService struct {
+	state *state.StateDB
+
+	tamper func(res *accountProof)
+}
+
+func (s *proofService) GetProof(ctx context.Context, address common.Address, keys []common.Hash, block rpc.BlockNumberOrHash) (*accountProof, error) {
+	accountNodes, err := s.state.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	res := &accountProof{
+		Address:      address,
+		AccountProof: toHexBytes(accountNodes),
+		Balance:      (*hexutil.Big)(s.state.GetBalance(address)),
+		CodeHash:     s.state.GetCodeHash(address),
+		Nonce:        hexutil.Uint64(s.state.GetNonce(address)),
+		StorageHash:  types.EmptyRootHash,
+	}
+	if storageTrie := s.state.StorageTrie(address); storageTrie != nil {
+		res.StorageHash = storageTrie.Hash()
+	}
+	for _, key := range keys {
+		storageNodes, err := s.state.GetStorageProof(address, key)
+		if err != nil {
+			return nil, err
+		}
+		res.StorageProof = append(res.StorageProof, storageProof{
+			Key:   key.Hex(),
+			Value: (*hexutil.Big)(s.state.GetState(address, key).Big()),
+			Proof: toHexBytes(storageNodes),
+		})
+	}
+	if s.tamper != nil {
+		s.tamper(res)
+	}
+	return res, nil
+}
+
+func toHexBytes(nodes [][]byte) []hexutil.Bytes {
+	out := make([]hexutil.Bytes, len(nodes))
+	for i, n := range nodes {
+		out[i] = n
+	}
+	return out
+}
+
+var (
+	proofContract = common.HexToAddress("0x00000000000000000000000000000000000c0de1")
+	proofEOA      = common.HexToAddress("0x00000000000000000000000000000000000e0a01")
+)
+
+// newProofState commits a state holding a contract with a few slots and an account without storage
+func newProofState(t *testing.T) (*state.StateDB, common.Hash) {
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	st, err := state.New(common.Hash{}, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetNonce(proofContract, 1)
+	st.SetCode(proofContract, []byte{0x60, 0x00})
+	for i := uint64(0); i < 20; i++ {
+		st.SetState(proofContract, slotOf(i), slotOf(i*1000+42))
+	}
+	st.SetState(proofContract, dataSlot(slotOf(3), 0), common.HexToHash("0xff00000000000000000000000000000000000000000000000000000000000001"))
+	st.SetBalance(proofEOA, big.NewInt(1e18))
+	root, err := st.Commit(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err = state.New(root, db, nil); err != nil {
+		t.Fatal(err)
+	}
+	return st, root
+}
+
+func newTestProofReader(t *testing.T, svc *proofService, root common.Hash) *ProofStorageReader {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", svc); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	return NewProofStorageReader(client, root)
+}
+
+func TestProofStorageReader(t *testing.T) {
+	st, root := newProofState(t)
+	svc := &proofService{state: st}
+	r := newTestProofReader(t, svc, root)
+	ctx := context.Background()
+
+	absent := slotOf(1000)
+	slots := []common.Hash{slotOf(0), slotOf(7), dataSlot(slotOf(3), 0), absent}
+	values, err := r.BatchStorageAt(ctx, proofContract, slots, nil)
+	if err != nil {
+		t.Fatalf("valid proof error: %v", err)
+	}
+	for i, s := range slots {
+		if want := st.GetState(proofContract, s); common.BytesToHash(values[i]) != want || len(values[i]) != 32 {
+			t.Errorf("slot %s = %x, want %s", s.Hex(), values[i], want.Hex())
+		}
+	}
+	if common.BytesToHash(values[3]) != (common.Hash{}) {
+		t.Errorf("absent slot = %x, want zero", values[3])
+	}
+
+	value, err := r.StorageAt(ctx, proofEOA, slotOf(0), nil)
+	if err != nil {
+		t.Fatalf("empty storage proof error: %v", err)
+	}
+	if common.BytesToHash(value) != (common.Hash{}) {
+		t.Errorf("empty storage slot = %x, want zero", value)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		account common.Address
+		slot    common.Hash
+		root    common.Hash
+		tamper  func(res *accountProof)
+	}{
+		{"tampered value", proofContract, slotOf(7), root, func(res *accountProof) {
+			res.StorageProof[0].Value = (*hexutil.Big)(big.NewInt(7043))
+		}},
+		{"wrong storage hash", proofContract, slotOf(7), root, func(res *accountProof) {
+			res.StorageHash = types.EmptyRootHash
+		}},
+		{"wrong state root", proofContract, slotOf(7), common.HexToHash("0x01"), nil},
+		{"tampered proof node", proofContract, slotOf(7), root, func(res *accountProof) {
+			last := res.StorageProof[0].Proof[len(res.StorageProof[0].Proof)-1]
+			last[len(last)-1] ^= 1
+		}},
+		{"missing storage proof", proofContract, slotOf(7), root, func(res *accountProof) {
+			res.StorageProof = nil
+		}},
+		{"absent slot with a value", proofContract, absent, root, func(res *accountProof) {
+			res.StorageProof[0].Value = (*hexutil.Big)(big.NewInt(1))
+		}},
+		{"empty storage with a value", proofEOA, slotOf(0), root, func(res *accountProof) {
+			res.StorageProof[0].Value = (*hexutil.Big)(big.NewInt(1))
+		}},
+		{"empty storage with a storage hash", proofEOA, slotOf(0), root, func(res *accountProof) {
+			res.StorageHash = common.HexToHash("0x01")
+		}},
+	} {
+		svc.tamper = tc.tamper
+		r := newTestProofReader(t, svc, tc.root)
+		_, err := r.StorageAt(ctx, tc.account, tc.slot, nil)
+		if !errors.Is(err, ErrProofVerification) {
+			t.Errorf("%s: want ErrProofVerification, got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package storagescan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// querySelector is one step of a query path, a .field or a [index] or [key]
+type querySelector struct {
+	field string
+
+	key string
+
+	isField bool
+}
+
+func (s querySelector) String() string {
+	if s.isField {
+		return "." + s.field
+	}
+	return "[" + s.key + "]"
+}
+
+// Query reads the value at path, a variable name followed by .field and [index] or [key] selectors, e.g.
+// "slice5[1].value", "balances[0xabc].amount", "ticks[-10]" or `names["a.b"]`,
+// keys holding '.', ']' or spaces are quoted with " or ', a quote inside is escaped with \
+func (c *Contract) Query(path string) (interface{}, error) {
+	name, selectors, err := parseQueryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	// names of vyper module variables hold a dot, e.g. "ownable.owner"
+	if _, ok := c.Variables[name]; !ok {
+		joined := name
+		for i, s := range selectors {
+			if !s.isField {
+				break
+			}
+			joined += "." + s.field
+			if _, ok = c.Variables[joined]; ok {
+				name, selectors = joined, selectors[i+1:]
+				break
+			}
+		}
+	}
+	value, err := c.GetVariableValue(name)
+	if err != nil {
+		return nil, err
+	}
+	at := name
+	for _, s := range selectors {
+		if value, err = s.apply(value); err != nil {
+			return nil, fmt.Errorf("query %s%s error: %w", at, s, err)
+		}
+		at += s.String()
+	}
+	return value, nil
+}
+
+func (s querySelector) apply(value interface{}) (interface{}, error) {
+	if s.isField {
+		sv, ok := value.(StructValueI)
+		if !ok {
+			return nil, fmt.Errorf("not a struct")
+		}
+		return sv.Field(s.field)
+	}
+	switch v := value.(type) {
+	case MappingValueI:
+		return v.Key(s.key)
+	case SliceArrayValueI:
+		i, err := parseQueryIndex(s.key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q", s.key)
+		}
+		return v.Index(i)
+	default:
+		return nil, fmt.Errorf("not a mapping, array or slice")
+	}
+}
+
+// parseQueryIndex reads a decimal index, or a hex one with a 0x prefix, like the uint keys of a mapping
+func parseQueryIndex(key string) (uint64, error) {
+	if strings.HasPrefix(key, "0x") || strings.HasPrefix(key, "0X") {
+		return strconv.ParseUint(key[2:], 16, 64)
+	}
+	return strconv.ParseUint(key, 10, 64)
+}
+
+// parseQueryPath splits path into the variable name and its selectors
+func parseQueryPath(path string) (string, []querySelector, error) {
+	i := identifierEnd(path, 0)
+	if i == 0 {
+		return "", nil, fmt.Errorf("invalid query %q: missing variable name", path)
+	}
+	name := path[:i]
+
+	var selectors []querySelector
+	for i < len(path) {
+		switch path[i] {
+		case '.':
+			end := identifierEnd(path, i+1)
+			if end == i+1 {
+				return "", nil, fmt.Errorf("invalid query %q: missing field name at offset %d", path, i+1)
+			}
+			selectors = append(selectors, querySelector{field: path[i+1 : end], isField: true})
+			i = end
+		case '[':
+			key, end, err := parseQueryKey(path, i+1)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid query %q: %v", path, err)
+			}
+			selectors = append(selectors, querySelector{key: key})
+			i = end
+		default:
+			return "", nil, fmt.Errorf("invalid query %q: unexpected %q at offset %d", path, path[i], i)
+		}
+	}
+	return name, selectors, nil
+}
+
+// identifierEnd returns the end of the solidity identifier starting at begin
+func identifierEnd(path string, begin int) int {
+	i := begin
+	for i < len(path) {
+		c := path[i]
+		isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+		if !isLetter && (i == begin || c < '0' || c > '9') {
+			break
+		}
+		i++
+	}
+	return i
+}
+
+// parseQueryKey reads the key starting at begin, right after '[', and returns it with the offset after ']'
+func parseQueryKey(path string, begin int) (string, int, error) {
+	i := begin
+	for i < len(path) && path[i] == ' ' {
+		i++
+	}
+	if i < len(path) && (path[i] == '"' || path[i] == '\'') {
+		quote := path[i]
+		var key strings.Builder
+		for i++; i < len(path) && path[i] != quote; i++ {
+			if path[i] == '\\' && i+1 < len(path) {
+				i++
+			}
+			key.WriteByte(path[i])
+		}
+		if i == len(path) {
+			return "", 0, fmt.Errorf("unterminated quoted key at offset %d", begin)
+		}
+		i++
+		for i < len(path) && path[i] == ' ' {
+			i++
+		}
+		if i == len(path) || path[i] != ']' {
+			return "", 0, fmt.Errorf("missing ] at offset %d", i)
+		}
+		return key.String(), i + 1, nil
+	}
+
+	end := strings.IndexByte(path[i:], ']')
+	if end < 0 {
+		return "", 0, fmt.Errorf("missing ] at offset %d", len(path))
+	}
+	key := strings.TrimSpace(path[i : i+end])
+	if key == "" {
+		return "", 0, fmt.Errorf("empty key at offset %d", begin)
+	}
+	return key, i + end + 1, nil
+}
@@ -0,0 +1,72 @@
+package storagescan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestParseQueryPath(t *testing.T) {
+	tests := []struct {
+		path      string
+		name      string
+		selectors []querySelector
+		err       string
+	}{
+		{path: "a", name: "a"},
+		{path: "$a_1.b_2", name: "$a_1", selectors: []querySelector{{field: "b_2", isField: true}}},
+		{path: "slice5[1].value", name: "slice5", selectors: []querySelector{{key: "1"}, {field: "value", isField: true}}},
+		{path: "m[ 0xAbC ][-10]", name: "m", selectors: []querySelector{{key: "0xAbC"}, {key: "-10"}}},
+		{path: `names["a.b]"]`, name: "names", selectors: []querySelector{{key: "a.b]"}}},
+		{path: `names[ 'it\'s' ]`, name: "names", selectors: []querySelector{{key: "it's"}}},
+		{path: `names["a\\b\"c"]`, name: "names", selectors: []querySelector{{key: `a\b"c`}}},
+		{path: `names[""]`, name: "names", selectors: []querySelector{{key: ""}}},
+
+		{path: "", err: "missing variable name"},
+		{path: "1a", err: "missing variable name"},
+		{path: "a.", err: "missing field name at offset 2"},
+		{path: "a.1", err: "missing field name at offset 2"},
+		{path: "a[1]x", err: `unexpected 'x' at offset 4`},
+		{path: "a[1", err: "missing ] at offset 3"},
+		{path: "a[ ]", err: "empty key at offset 2"},
+		{path: `a["b`, err: "unterminated quoted key at offset 2"},
+		{path: `a["b" x]`, err: "missing ] at offset 6"},
+	}
+	for _, tt := range tests {
+		name, selectors, err := parseQueryPath(tt.path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseQueryPath(%q) error = %v, want %s", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || name != tt.name || !reflect.DeepEqual(selectors, tt.selectors) {
+			t.Errorf("parseQueryPath(%q) = %q, %+v, %v, want %q, %+v", tt.path, name, selectors, err, tt.name, tt.selectors)
+		}
+	}
+}
+
+func TestQueryIndex(t *testing.T) {
+	st := testStorage{slotOf(0): slotOf(20)}
+	for i := uint64(0); i < 20; i++ {
+		st[dataSlot(slotOf(0), i)] = slotOf(i)
+	}
+	checkQueries(t, uintSliceLayout, st, []queryTest{
+		{path: "big[10]", want: "10"},
+		{path: "big[010]", want: "10"},
+		{path: "big[0x10]", want: "16"},
+		{path: "big[0X0a]", want: "10"},
+	})
+
+	c, err := NewContractFromLayout(common.Address{}, NewSnapshotReader(common.Address{}, st), uintSliceLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"big[1_0]", "big[0x1_0]", "big[-1]", "big[+1]", "big[0b1]", "big[1e1]"} {
+		if _, err := c.Query(path); err == nil || !strings.Contains(err.Error(), "invalid index") {
+			t.Errorf("query %s error = %v, want invalid index", path, err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package storagescan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http"
+	"sync"
+)
+
+// defaultBatchSize keeps batches below the request limits of common rpc providers
+const defaultBatchSize = 100
+
+// ErrReaderClosed is returned by reads on a StorageReader after Close
+var ErrReaderClosed = errors.New("storage reader closed")
+
+// StorageReader reads raw storage slots of any contract, it is meant to be long-lived and shared
+// a nil block reads the latest state
+type StorageReader interface {
+	StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error)
+
+	Close()
+}
+
+// RPCStorageReader reads storage through one JSON-RPC connection that is reused for every slot
+type RPCStorageReader struct {
+	// BatchSize is the maximum number of slots sent in one batch request, zero means defaultBatchSize
+	BatchSize int
+
+	rpcNode string
+
+	mu sync.Mutex
+
+	eth *ethclient.Client
+
+	rpc *rpc.Client
+
+	// owned is true when the connection was dialed by the reader and must be closed by it
+	owned bool
+
+	closed bool
+
+	// batchRejected is set once the endpoint refused a batch request, later batches are read slot by slot
+	batchRejected bool
+}
+
+// NewRPCStorageReader returns a reader for rpcNode, the connection is dialed on the first read
+func NewRPCStorageReader(rpcNode string) *RPCStorageReader {
+	return &RPCStorageReader{
+		rpcNode: rpcNode,
+		owned:   true,
+	}
+}
+
+// NewStorageReaderFromClient returns a reader on top of a caller owned client, Close does not close it
+func NewStorageReaderFromClient(client *ethclient.Client) *RPCStorageReader {
+	return &RPCStorageReader{
+		eth: client,
+	}
+}
+
+// NewStorageReaderFromRPCClient returns a reader on top of a caller owned client, Close does not close it
+func NewStorageReaderFromRPCClient(client *rpc.Client) *RPCStorageReader {
+	return &RPCStorageReader{
+		eth: ethclient.NewClient(client),
+		rpc: client,
+	}
+}
+
+// client returns the connection of the reader, the rpc client is nil when built from an ethclient.Client
+func (r *RPCStorageReader) client(ctx context.Context) (*ethclient.Client, *rpc.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, nil, ErrReaderClosed
+	}
+	if r.eth != nil {
+		return r.eth, r.rpc, nil
+	}
+	cli, err := rpc.DialContext(ctx, r.rpcNode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial rpc node error: %w", err)
+	}
+	r.rpc = cli
+	r.eth = ethclient.NewClient(cli)
+	return r.eth, r.rpc, nil
+}
+
+func (r *RPCStorageReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	eth, cli, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	if cli != nil {
+		var result hexutil.Bytes
+		err = cli.CallContext(ctx, &result, "eth_getStorageAt", account, slot, blockArg(block))
+		value = result
+	} else {
+		var number *big.Int
+		number, err = blockNumberArg(block)
+		if err == nil {
+			value, err = eth.StorageAt(ctx, account, slot, number)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get storage at slot %s error: %w", slot.Hex(), err)
+	}
+	return value, nil
+}
+
+// BatchStorageAt reads slots with batched eth_getStorageAt calls, it falls back to one call per slot
+// when the reader has no rpc.Client or the endpoint rejects batch requests
+func (r *RPCStorageReader) BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	_, cli, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(slots))
+	if cli == nil || r.isBatchRejected() {
+		if err = r.storageAtEach(ctx, account, slots, block, values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	for begin := 0; begin < len(slots); begin += batchSize {
+		end := begin + batchSize
+		if end > len(slots) {
+			end = len(slots)
+		}
+		results := make([]hexutil.Bytes, end-begin)
+		elems := make([]rpc.BatchElem, end-begin)
+		for i, slot := range slots[begin:end] {
+			elems[i] = rpc.BatchElem{
+				Method: "eth_getStorageAt",
+				Args:   []interface{}{account, slot, blockArg(block)},
+				Result: &results[i],
+			}
+		}
+		err = cli.BatchCallContext(ctx, elems)
+		if err != nil {
+			if ctx.Err() != nil || !isBatchRejection(err) {
+				return nil, fmt.Errorf("batch get storage error: %w", err)
+			}
+			r.mu.Lock()
+			r.batchRejected = true
+			r.mu.Unlock()
+			if err = r.storageAtEach(ctx, account, slots[begin:], block, values[begin:]); err != nil {
+				return nil, err
+			}
+			return values, nil
+		}
+		for i, elem := range elems {
+			if elem.Error != nil {
+				// retry alone, some endpoints answer batches with per element errors
+				values[begin+i], err = r.StorageAt(ctx, account, slots[begin+i], block)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			values[begin+i] = results[i]
+		}
+	}
+	return values, nil
+}
+
+// isBatchRejection tells whether err means the endpoint does not accept batch requests, as opposed to a
+// failure worth retrying later such as a timeout, a dropped connection or a server error
+func isBatchRejection(err error) bool {
+	// a single error object answered to a batch
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return true
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed,
+			http.StatusRequestEntityTooLarge, http.StatusNotImplemented:
+			return true
+		}
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		// invalid request or method not found
+		return rpcErr.ErrorCode() == -32600 || rpcErr.ErrorCode() == -32601
+	}
+	return false
+}
+
+func (r *RPCStorageReader) isBatchRejected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batchRejected
+}
+
+func (r *RPCStorageReader) storageAtEach(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash, values [][]byte) (err error) {
+	for i, slot := range slots {
+		values[i], err = r.StorageAt(ctx, account, slot, block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the connection if the reader dialed it, further reads return ErrReaderClosed
+func (r *RPCStorageReader) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.owned && r.eth != nil {
+		r.eth.Close()
+	}
+}
+
+// GenReaderStorageValueFunc binds reader to a contract address and block, every slot is read over the reader's connection
+func GenReaderStorageValueFunc(ctx context.Context, reader StorageReader, contractAddr common.Address, block *rpc.BlockNumberOrHash) GetValueStorageAtFunc {
+	return func(s common.Hash) ([]byte, error) {
+		return reader.StorageAt(ctx, contractAddr, s, block)
+	}
+}
+
+// blockArg encodes block as the block parameter of eth_getStorageAt, hashes use the EIP-1898 object form
+func blockArg(block *rpc.BlockNumberOrHash) interface{} {
+	if block == nil {
+		return rpc.LatestBlockNumber
+	}
+	if hash, ok := block.Hash(); ok {
+		return map[string]interface{}{
+			"blockHash":        hash,
+			"requireCanonical": block.RequireCanonical,
+		}
+	}
+	if number, ok := block.Number(); ok {
+		return number
+	}
+	return rpc.LatestBlockNumber
+}
+
+// blockNumberArg converts block for ethclient, which can only address blocks by number
+func blockNumberArg(block *rpc.BlockNumberOrHash) (*big.Int, error) {
+	if block == nil {
+		return nil, nil
+	}
+	if _, ok := block.Hash(); ok {
+		return nil, fmt.Errorf("reading at a block hash requires a rpc.Client")
+	}
+	number, ok := block.Number()
+	if !ok || number == rpc.LatestBlockNumber {
+		return nil, nil
+	}
+	if number < 0 {
+		return nil, fmt.Errorf("block %d is not supported by ethclient.Client", number.Int64())
+	}
+	return big.NewInt(number.Int64()), nil
+}
+
+// BlockNumber pins reads to the block with the given number, nil means latest
+func BlockNumber(number *big.Int) *rpc.BlockNumberOrHash {
+	if number == nil {
+		return nil
+	}
+	block := rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number.Int64()))
+	return &block
+}
+
+// BlockHash pins reads to the block with the given hash
+func BlockHash(hash common.Hash) *rpc.BlockNumberOrHash {
+	block := rpc.BlockNumberOrHashWithHash(hash, false)
+	return &block
+}
@@ -0,0 +1,147 @@
+package storagescan
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// storageServer answers eth_getStorageAt with the slot number as value, batch requests are answered
+// by batchReply when it writes a response
+type storageServer struct {
+	mu sync.Mutex
+
+	batches, singles int
+
+	batchReply func(w http.ResponseWriter) bool
+}
+
+func (s *storageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answer := func(req rpcRequest) map[string]interface{} {
+		var slot common.Hash
+		json.Unmarshal(req.Params[1], &slot)
+		return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": slot.Hex()}
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		s.batches++
+		if s.batchReply != nil && s.batchReply(w) {
+			return
+		}
+		var reqs []rpcRequest
+		json.Unmarshal(body, &reqs)
+		resps := make([]map[string]interface{}, len(reqs))
+		for i, req := range reqs {
+			resps[i] = answer(req)
+		}
+		json.NewEncoder(w).Encode(resps)
+		return
+	}
+	s.singles++
+	var req rpcRequest
+	json.Unmarshal(body, &req)
+	json.NewEncoder(w).Encode(answer(req))
+}
+
+func TestBatchStorageAtRejection(t *testing.T) {
+	tests := []struct {
+		name string
+
+		reply func(w http.ResponseWriter) bool
+
+		rejected bool
+	}{
+		{
+			name: "single error object",
+			reply: func(w http.ResponseWriter) bool {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch requests are not supported"}}`))
+				return true
+			},
+			rejected: true,
+		},
+		{
+			name: "method not allowed",
+			reply: func(w http.ResponseWriter) bool {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return true
+			},
+			rejected: true,
+		},
+		{
+			name: "server error",
+			reply: func(w http.ResponseWriter) bool {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return true
+			},
+		},
+		{
+			name: "rate limited",
+			reply: func(w http.ResponseWriter) bool {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return true
+			},
+		},
+	}
+	slots := []common.Hash{common.BigToHash(common.Big1), common.BigToHash(common.Big2)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &storageServer{}
+			failed := false
+			server.batchReply = func(w http.ResponseWriter) bool {
+				if failed {
+					return false
+				}
+				failed = true
+				return tt.reply(w)
+			}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			client, err := rpc.Dial(httpServer.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			reader := NewStorageReaderFromRPCClient(client)
+
+			values, err := reader.BatchStorageAt(context.Background(), common.Address{}, slots, nil)
+			if tt.rejected {
+				if err != nil || common.BytesToHash(values[1]) != slots[1] {
+					t.Fatalf("BatchStorageAt = %x, %v, want the slots read one by one", values, err)
+				}
+			} else if err == nil {
+				t.Fatalf("BatchStorageAt error = nil, want the batch error")
+			}
+			if reader.isBatchRejected() != tt.rejected {
+				t.Fatalf("batchRejected = %v, want %v", reader.isBatchRejected(), tt.rejected)
+			}
+
+			// the next batch is sent as a batch unless the endpoint rejected batches
+			batches := server.batches
+			if values, err = reader.BatchStorageAt(context.Background(), common.Address{}, slots, nil); err != nil {
+				t.Fatal(err)
+			}
+			if common.BytesToHash(values[0]) != slots[0] || common.BytesToHash(values[1]) != slots[1] {
+				t.Fatalf("BatchStorageAt = %x, want %x", values, slots)
+			}
+			if sent := server.batches > batches; sent == tt.rejected {
+				t.Fatalf("batch sent = %v after rejected = %v", sent, tt.rejected)
+			}
+		})
+	}
+}
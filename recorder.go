@@ -0,0 +1,138 @@
+package storagescan
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"os"
+	"sync"
+)
+
+// SnapshotRecorder records every slot read through it, the recording is written in the
+// {"accounts": {"address": {"storage": {...}}}} layout so LoadSnapshot can replay it offline
+type SnapshotRecorder struct {
+	mu sync.Mutex
+
+	storage map[common.Address]map[common.Hash]common.Hash
+}
+
+func NewSnapshotRecorder() *SnapshotRecorder {
+	return &SnapshotRecorder{
+		storage: map[common.Address]map[common.Hash]common.Hash{},
+	}
+}
+
+func (r *SnapshotRecorder) record(address common.Address, slot common.Hash, value []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.storage[address] == nil {
+		r.storage[address] = map[common.Hash]common.Hash{}
+	}
+	r.storage[address][slot] = common.BytesToHash(value)
+}
+
+// Wrap records the slots read by f, which must read contractAddr
+func (r *SnapshotRecorder) Wrap(contractAddr common.Address, f GetValueStorageAtFunc) GetValueStorageAtFunc {
+	return func(s common.Hash) ([]byte, error) {
+		value, err := f(s)
+		if err != nil {
+			return nil, err
+		}
+		r.record(contractAddr, s, value)
+		return value, nil
+	}
+}
+
+// Reader returns a StorageReader recording every slot read with reader
+func (r *SnapshotRecorder) Reader(reader StorageReader) BatchStorageReader {
+	return &recordingStorageReader{
+		recorder: r,
+		reader:   reader,
+	}
+}
+
+// Storage returns a copy of the slots recorded for address
+func (r *SnapshotRecorder) Storage(address common.Address) map[common.Hash]common.Hash {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	storage := make(map[common.Hash]common.Hash, len(r.storage[address]))
+	for k, v := range r.storage[address] {
+		storage[k] = v
+	}
+	return storage
+}
+
+// Reset drops the recording
+func (r *SnapshotRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.storage = map[common.Address]map[common.Hash]common.Hash{}
+}
+
+// WriteJSON writes the recording as an indented snapshot, slots are sorted so recordings diff cleanly
+func (r *SnapshotRecorder) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	accounts := make(map[string]snapshotAccountJson, len(r.storage))
+	for address, slots := range r.storage {
+		storage := make(map[string]string, len(slots))
+		for k, v := range slots {
+			storage[k.Hex()] = v.Hex()
+		}
+		accounts[address.Hex()] = snapshotAccountJson{Storage: storage}
+	}
+	r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"accounts": accounts})
+}
+
+// WriteFile writes the recording to the file at path
+func (r *SnapshotRecorder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = r.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type snapshotAccountJson struct {
+	Storage map[string]string `json:"storage"`
+}
+
+type recordingStorageReader struct {
+	recorder *SnapshotRecorder
+
+	reader StorageReader
+}
+
+func (r *recordingStorageReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	value, err := r.reader.StorageAt(ctx, account, slot, block)
+	if err != nil {
+		return nil, err
+	}
+	r.recorder.record(account, slot, value)
+	return value, nil
+}
+
+func (r *recordingStorageReader) BatchStorageAt(ctx context.Context, account common.Address, slots []common.Hash, block *rpc.BlockNumberOrHash) ([][]byte, error) {
+	values, err := batchStorageAt(ctx, r.reader, account, slots, block)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		r.recorder.record(account, slots[i], value)
+	}
+	return values, nil
+}
+
+// Close closes the underlying reader
+func (r *recordingStorageReader) Close() {
+	r.reader.Close()
+}
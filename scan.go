@@ -2,6 +2,7 @@ package storagescan
 
 import (
 	"context"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -10,32 +11,71 @@ import (
 
 type SolidityTyp uint8
 
-type GetValueStorageAtFunc func(s common.Hash) []byte
+// maxDynamicSlotCount bounds the number of data slots read for a single string or bytes, a larger length
+// means the slot does not hold a string header
+const maxDynamicSlotCount = 1 << 20
+
+// GetValueStorageAtFunc reads the raw 32 bytes stored at slot s, a failed read must return an error
+// rather than empty bytes, otherwise it would be decoded as a zero value
+type GetValueStorageAtFunc func(s common.Hash) ([]byte, error)
 
 // GenGetStorageValueFunc this is a wrapper for the storage at function
+// it dials a new connection for every slot, long-running callers should use GenReaderStorageValueFunc
 func GenGetStorageValueFunc(ctx context.Context, rpcNode string, contractAddr common.Address) GetValueStorageAtFunc {
-	return func(s common.Hash) []byte {
+	return func(s common.Hash) ([]byte, error) {
 		cli, err := ethclient.DialContext(ctx, rpcNode)
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("dial rpc node error: %w", err)
 		}
+		defer cli.Close()
 		var value []byte
 		value, err = cli.StorageAt(ctx, contractAddr, s, nil)
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("get storage at slot %s error: %w", s.Hex(), err)
 		}
-		return value
+		return value, nil
+	}
+}
+
+// rebaseSlot returns slot moved forward by base slots
+func rebaseSlot(base, slot common.Hash) common.Hash {
+	return common.BigToHash(new(big.Int).Add(base.Big(), slot.Big()))
+}
+
+// storageSlots returns the number of whole slots a variable occupies when it starts a new slot
+func storageSlots(v Variable) uint64 {
+	return (uint64(v.Len()) + 255) / 256
+}
+
+// isPackedUnit reports whether array elements of type v share slots
+func isPackedUnit(v Variable) bool {
+	switch v.Typ() {
+	case IntTy, UintTy, BoolTy, AddressTy, BytesTy:
+		return v.Len() <= 128
 	}
+	return false
+}
+
+// packedPosition returns the slot and bit offset of element i of a packed array starting at slotIndex,
+// an element never crosses a slot boundary, e.g. ten uint24 fit in a slot and the eleventh starts the next
+func packedPosition(slotIndex common.Hash, i uint64, bitLength uint) (common.Hash, uint) {
+	perSlot := uint64(256 / bitLength)
+	slot := new(big.Int).Add(slotIndex.Big(), new(big.Int).SetUint64(i/perSlot))
+	return common.BigToHash(slot), uint(i%perSlot) * bitLength
 }
 
 type Variable interface {
 	Typ() SolidityTyp
 
-	Value(f GetValueStorageAtFunc) interface{}
+	Value(f GetValueStorageAtFunc) (interface{}, error)
 
 	Len() uint
 
 	Slot() common.Hash
+
+	// Rebase returns a copy of the variable moved forward by base slots, the variable itself is never
+	// modified so one parsed layout can be read by concurrent goroutines
+	Rebase(base common.Hash) Variable
 }
 
 // Type enumerator
@@ -50,6 +90,7 @@ const (
 	AddressTy
 	BytesTy
 	StructTy
+	DynamicBytesTy
 )
 
 func (t SolidityTyp) String() string {
@@ -74,6 +115,8 @@ func (t SolidityTyp) String() string {
 		return "bytes"
 	case StructTy:
 		return "struct"
+	case DynamicBytesTy:
+		return "dynamic_bytes"
 	default:
 		return "unknown"
 	}
@@ -91,8 +134,11 @@ func (s SolidityInt) Typ() SolidityTyp {
 	return IntTy
 }
 
-func (s SolidityInt) Value(f GetValueStorageAtFunc) interface{} {
-	v := f(s.SlotIndex)
+func (s SolidityInt) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
 
 	vb := common.BytesToHash(v).Big()
 	vb.Rsh(vb, s.Offset)
@@ -105,22 +151,14 @@ func (s SolidityInt) Value(f GetValueStorageAtFunc) interface{} {
 	vb.And(vb, mask)
 
 	// signBit is 0 if the value is positive and 1 if it is negative
-	signBit := new(big.Int)
-	signBit.Rsh(vb, s.Length-1)
-	if signBit.Uint64() == 0 {
-		return vb.Uint64()
-
-	} else {
-		// flip the bits
-		vb.Sub(vb, big.NewInt(1))
-		r := make([]byte, 0)
-		for _, b := range vb.Bytes() {
-			r = append(r, ^b)
-		}
-		// convert back to big int
-		return -new(big.Int).SetBytes(r).Int64()
+	if vb.Bit(int(s.Length)-1) == 1 {
+		// two's complement, subtract 2^length
+		modulus := new(big.Int).Lsh(big.NewInt(1), s.Length)
+		vb.Sub(vb, modulus)
 	}
 
+	return IntValue{vb}, nil
+
 }
 
 func (s SolidityInt) Len() uint {
@@ -131,6 +169,11 @@ func (s SolidityInt) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityInt) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityUint struct {
 	SlotIndex common.Hash
 
@@ -143,8 +186,11 @@ func (s SolidityUint) Typ() SolidityTyp {
 	return UintTy
 }
 
-func (s SolidityUint) Value(f GetValueStorageAtFunc) interface{} {
-	v := f(s.SlotIndex)
+func (s SolidityUint) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
 	vb := common.BytesToHash(v).Big()
 	vb.Rsh(vb, s.Offset)
 
@@ -153,12 +199,7 @@ func (s SolidityUint) Value(f GetValueStorageAtFunc) interface{} {
 
 	vb.And(vb, mask)
 
-	// if vb > uint64 max, return string, else return uint64
-	if vb.Cmp(big.NewInt(0).SetUint64(1<<64-1)) > 0 {
-		return vb.String()
-	} else {
-		return vb.Uint64()
-	}
+	return UintValue{vb}, nil
 
 }
 
@@ -170,6 +211,11 @@ func (s SolidityUint) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityUint) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityAddress struct {
 	SlotIndex common.Hash
 
@@ -180,8 +226,11 @@ func (s SolidityAddress) Typ() SolidityTyp {
 	return AddressTy
 }
 
-func (s SolidityAddress) Value(f GetValueStorageAtFunc) interface{} {
-	v := f(s.SlotIndex)
+func (s SolidityAddress) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
 	vb := common.BytesToHash(v).Big()
 	vb.Rsh(vb, s.Offset)
 
@@ -190,7 +239,7 @@ func (s SolidityAddress) Value(f GetValueStorageAtFunc) interface{} {
 
 	vb.And(vb, lengthOffset)
 
-	return common.BytesToAddress(vb.Bytes())
+	return AddressValue{common.BytesToAddress(vb.Bytes())}, nil
 }
 
 func (s SolidityAddress) Len() uint {
@@ -201,6 +250,11 @@ func (s SolidityAddress) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityAddress) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityBool struct {
 	SlotIndex common.Hash
 
@@ -212,8 +266,11 @@ func (s SolidityBool) Typ() SolidityTyp {
 
 }
 
-func (s SolidityBool) Value(f GetValueStorageAtFunc) interface{} {
-	v := f(s.SlotIndex)
+func (s SolidityBool) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
 	vb := common.BytesToHash(v).Big()
 	vb.Rsh(vb, s.Offset)
 
@@ -221,7 +278,7 @@ func (s SolidityBool) Value(f GetValueStorageAtFunc) interface{} {
 	lengthOffset.SetBit(lengthOffset, 8, 1).Sub(lengthOffset, big.NewInt(1))
 
 	vb.And(vb, lengthOffset)
-	return vb.Uint64() == 1
+	return BoolValue(vb.Uint64() == 1), nil
 
 }
 
@@ -233,6 +290,11 @@ func (s SolidityBool) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityBool) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityString struct {
 	SlotIndex common.Hash
 }
@@ -245,76 +307,97 @@ func (s SolidityString) Typ() SolidityTyp {
 // the length of the string exceeds 31 bytes (0x1f), and the entire slot stores the length of the string*2+1
 // the length of the string does not exceed 31 bytes, the rightmost bit of the entire slot stores the character length*2, and the leftmost stores the string content
 // if the last digit is odd then it is a long string, otherwise it is a short  string
-func (s SolidityString) Value(f GetValueStorageAtFunc) interface{} {
-	data := f(s.SlotIndex)
-	v := common.BytesToHash(data).Big()
-
-	// get the last digit of v
-	lastDigit := v.Bit(0)
-
-	//  equal to 1 means it is a long string
-	if lastDigit == 1 {
-		// get the current string length bit
-		length := new(big.Int)
-		length.Sub(v, big.NewInt(1)).Div(length, big.NewInt(2)).Mul(length, big.NewInt(8))
-
-		remainB := new(big.Int)
-		remainB.Mod(length, big.NewInt(256))
-
-		slotNum := new(big.Int)
-		if remainB.Uint64() == 0 {
-			slotNum.Div(length, big.NewInt(256))
-		} else {
-			slotNum.Div(length, big.NewInt(256)).Add(slotNum, big.NewInt(1))
-		}
-
-		firstSlotIndex := crypto.Keccak256Hash(s.SlotIndex.Bytes())
-
-		value := f(firstSlotIndex)
-
-		for i := int64(1); i < slotNum.Int64()-1; i++ {
-			nextSlot := new(big.Int)
-			nextSlot.Add(firstSlotIndex.Big(), big.NewInt(i))
-			nextValue := f(common.BigToHash(nextSlot))
-			value = append(value, nextValue...)
-		}
-
-		lastSlotIndex := new(big.Int)
-		lastSlotIndex.Add(firstSlotIndex.Big(), big.NewInt(slotNum.Int64()-1))
+func (s SolidityString) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	value, err := readDynamicBytes(f, s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
+	return StringValue(value), nil
+}
 
-		lastSlotValue := f(common.BigToHash(lastSlotIndex))
+func (s SolidityString) Len() uint {
+	return 256
+}
 
-		if remainB.Uint64() == 0 {
-			value = append(value, lastSlotValue...)
-		} else {
-			// move right to get the final value
-			lastValueBig := common.BytesToHash(lastSlotValue).Big()
-			lastValueBig.Rsh(lastValueBig, 256-uint(remainB.Uint64()))
-			value = append(value, lastValueBig.Bytes()...)
-		}
+func (s SolidityString) Slot() common.Hash {
+	return s.SlotIndex
+}
 
-		return string(value)
-	} else {
+func (s SolidityString) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
 
-		length := new(big.Int)
-		length.And(v, big.NewInt(0xff))
-		length.Div(length, big.NewInt(2)).Mul(length, big.NewInt(8))
+// SolidityDynamicBytes is the dynamic bytes type, it shares the encoding of string but holds binary data
+type SolidityDynamicBytes struct {
+	SlotIndex common.Hash
+}
 
-		v.Rsh(v, 256-uint(length.Uint64()))
+func (s SolidityDynamicBytes) Typ() SolidityTyp {
+	return DynamicBytesTy
+}
 
-		return string(v.Bytes())
+// Value returns the content as DynamicBytesValue, which prints as 0x prefixed hex
+func (s SolidityDynamicBytes) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	value, err := readDynamicBytes(f, s.SlotIndex)
+	if err != nil {
+		return nil, err
 	}
-
+	return DynamicBytesValue(value), nil
 }
 
-func (s SolidityString) Len() uint {
+func (s SolidityDynamicBytes) Len() uint {
 	return 256
 }
 
-func (s SolidityString) Slot() common.Hash {
+func (s SolidityDynamicBytes) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityDynamicBytes) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
+// readDynamicBytes reads the content of a string or bytes stored at slot
+// a short content (at most 31 bytes) is stored left aligned in the slot with length*2 in the lowest byte,
+// a long content stores length*2+1 in the slot and the data in the slots starting at keccak256(slot)
+func readDynamicBytes(f GetValueStorageAtFunc, slot common.Hash) ([]byte, error) {
+	data, err := f(slot)
+	if err != nil {
+		return nil, err
+	}
+	header := common.BytesToHash(data)
+	v := header.Big()
+
+	// equal to 1 means it is a long content
+	if v.Bit(0) == 0 {
+		length := header[31] / 2
+		if length > 31 {
+			return nil, fmt.Errorf("invalid short bytes length %d at slot %s", length, slot.Hex())
+		}
+		return header[:length], nil
+	}
+
+	length := new(big.Int).Rsh(v, 1)
+	if !length.IsUint64() || length.Uint64() > maxDynamicSlotCount*32 {
+		return nil, fmt.Errorf("invalid bytes length %v at slot %s", length, slot.Hex())
+	}
+	slotNum := (length.Uint64() + 31) / 32
+
+	firstSlotIndex := crypto.Keccak256Hash(slot.Bytes()).Big()
+	var value []byte
+	for i := uint64(0); i < slotNum; i++ {
+		nextSlot := new(big.Int).Add(firstSlotIndex, new(big.Int).SetUint64(i))
+		nextValue, err := f(common.BigToHash(nextSlot))
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, common.BytesToHash(nextValue).Bytes()...)
+	}
+	return value[:length.Uint64()], nil
+}
+
 type SolidityBytes struct {
 	SlotIndex common.Hash
 
@@ -327,8 +410,11 @@ func (s SolidityBytes) Typ() SolidityTyp {
 	return BytesTy
 }
 
-func (s SolidityBytes) Value(f GetValueStorageAtFunc) interface{} {
-	v := f(s.SlotIndex)
+func (s SolidityBytes) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
 	vb := common.BytesToHash(v).Big()
 	vb.Rsh(vb, s.Offset)
 
@@ -337,7 +423,8 @@ func (s SolidityBytes) Value(f GetValueStorageAtFunc) interface{} {
 
 	vb.And(vb, lengthOffset)
 
-	return string(common.TrimRightZeroes(vb.Bytes()))
+	// bytesN is stored like a uintN, the value is its N big-endian bytes
+	return BytesNValue(vb.FillBytes(make([]byte, s.Length/8))), nil
 
 }
 
@@ -349,6 +436,11 @@ func (s SolidityBytes) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityBytes) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 // bytes = byte[] = uint8[]
 
 type SoliditySlice struct {
@@ -361,8 +453,22 @@ func (s SoliditySlice) Typ() SolidityTyp {
 	return SliceTy
 }
 
-func (s SoliditySlice) Value(f GetValueStorageAtFunc) interface{} {
-	length := common.BytesToHash(f(s.SlotIndex)).Big().Uint64()
+func (s SoliditySlice) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	// the unit type of a parsed layout is built on first use
+	unitTyp, err := resolveVariable(s.UnitTyp)
+	if err != nil {
+		return nil, err
+	}
+	s.UnitTyp = unitTyp
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
+	lengthB := common.BytesToHash(v).Big()
+	if !lengthB.IsUint64() {
+		return nil, fmt.Errorf("invalid slice length %v at slot %s", lengthB, s.SlotIndex.Hex())
+	}
+	length := lengthB.Uint64()
 	valueSlotIndex := crypto.Keccak256Hash(s.SlotIndex.Bytes())
 
 	switch s.UnitTyp.Typ() {
@@ -373,7 +479,7 @@ func (s SoliditySlice) Value(f GetValueStorageAtFunc) interface{} {
 			length:        length,
 			uintBitLength: si.Length,
 			f:             f,
-		}
+		}, nil
 	case UintTy:
 		su := s.UnitTyp.(*SolidityUint)
 		return UintSliceValue{
@@ -381,7 +487,7 @@ func (s SoliditySlice) Value(f GetValueStorageAtFunc) interface{} {
 			length:        length,
 			uintBitLength: su.Length,
 			f:             f,
-		}
+		}, nil
 	case BytesTy:
 		sb := s.UnitTyp.(*SolidityBytes)
 		return BytesSliceValue{
@@ -389,43 +495,53 @@ func (s SoliditySlice) Value(f GetValueStorageAtFunc) interface{} {
 			length:        length,
 			uintBitLength: sb.Length,
 			f:             f,
-		}
+		}, nil
 	case StructTy:
 		ss := s.UnitTyp.(*SolidityStruct)
 		return StructSliceValue{
-			slotIndex:     valueSlotIndex,
-			length:        length,
-			filedValueMap: ss.FiledValueMap,
-			f:             f,
-		}
+			slotIndex:       valueSlotIndex,
+			length:          length,
+			filedValueMap:   ss.FiledValueMap,
+			f:               f,
+			structSlotCount: storageSlots(ss),
+		}, nil
 
 	case BoolTy:
 		return BoolSliceValue{
 			slotIndex: valueSlotIndex,
 			length:    length,
 			f:         f,
-		}
+		}, nil
 	case StringTy:
 		return StringSliceValue{
 			slotIndex: valueSlotIndex,
 			length:    length,
 			f:         f,
-		}
+		}, nil
+	case DynamicBytesTy:
+		return DynamicBytesSliceValue{
+			slotIndex: valueSlotIndex,
+			length:    length,
+			f:         f,
+		}, nil
 	case AddressTy:
 		return AddressSliceValue{
 			slotIndex: valueSlotIndex,
 			length:    length,
 			f:         f,
-		}
-	case SliceTy:
-		{
-			ss := s.UnitTyp.(*SoliditySlice)
-			return ss.Value(f)
-
-		}
+		}, nil
+	case SliceTy, ArrayTy, MappingTy:
+		// nested arrays, slices and mappings, every element starts a new slot
+		return VariableSliceValue{
+			slotIndex: valueSlotIndex,
+			length:    length,
+			unitTyp:   s.UnitTyp,
+			unitSlots: storageSlots(s.UnitTyp),
+			f:         f,
+		}, nil
 
 	}
-	return nil
+	return nil, fmt.Errorf("unsupported slice unit type %s", s.UnitTyp.Typ())
 
 }
 
@@ -437,6 +553,11 @@ func (s SoliditySlice) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SoliditySlice) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityArray struct {
 	SlotIndex common.Hash
 
@@ -449,7 +570,7 @@ func (s SolidityArray) Typ() SolidityTyp {
 	return ArrayTy
 }
 
-func (s SolidityArray) Value(f GetValueStorageAtFunc) interface{} {
+func (s SolidityArray) Value(f GetValueStorageAtFunc) (interface{}, error) {
 	switch s.UnitTyp.Typ() {
 	case IntTy:
 		si := s.UnitTyp.(*SolidityInt)
@@ -458,7 +579,7 @@ func (s SolidityArray) Value(f GetValueStorageAtFunc) interface{} {
 			length:        s.UnitLength,
 			uintBitLength: si.Length,
 			f:             f,
-		}
+		}, nil
 	case UintTy:
 		su := s.UnitTyp.(*SolidityUint)
 		return UintSliceValue{
@@ -466,7 +587,7 @@ func (s SolidityArray) Value(f GetValueStorageAtFunc) interface{} {
 			length:        s.UnitLength,
 			uintBitLength: su.Length,
 			f:             f,
-		}
+		}, nil
 	case BytesTy:
 		sb := s.UnitTyp.(*SolidityBytes)
 		return BytesSliceValue{
@@ -474,80 +595,122 @@ func (s SolidityArray) Value(f GetValueStorageAtFunc) interface{} {
 			length:        s.UnitLength,
 			uintBitLength: sb.Length,
 			f:             f,
-		}
+		}, nil
 	case StructTy:
 		ss := s.UnitTyp.(*SolidityStruct)
 		return StructSliceValue{
-			slotIndex:     s.SlotIndex,
-			length:        s.UnitLength,
-			filedValueMap: ss.FiledValueMap,
-			f:             f,
-		}
+			slotIndex:       s.SlotIndex,
+			length:          s.UnitLength,
+			filedValueMap:   ss.FiledValueMap,
+			f:               f,
+			structSlotCount: storageSlots(ss),
+		}, nil
 
 	case BoolTy:
 		return BoolSliceValue{
 			length:    s.UnitLength,
 			slotIndex: s.SlotIndex,
 			f:         f,
-		}
+		}, nil
 	case StringTy:
 		return StringSliceValue{
 			length:    s.UnitLength,
 			slotIndex: s.SlotIndex,
 			f:         f,
-		}
+		}, nil
+	case DynamicBytesTy:
+		return DynamicBytesSliceValue{
+			length:    s.UnitLength,
+			slotIndex: s.SlotIndex,
+			f:         f,
+		}, nil
 	case AddressTy:
 		return AddressSliceValue{
 			length:    s.UnitLength,
 			slotIndex: s.SlotIndex,
 			f:         f,
-		}
+		}, nil
 
+	case SliceTy, ArrayTy, MappingTy:
+		return VariableSliceValue{
+			slotIndex: s.SlotIndex,
+			length:    s.UnitLength,
+			unitTyp:   s.UnitTyp,
+			unitSlots: storageSlots(s.UnitTyp),
+			f:         f,
+		}, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("unsupported array unit type %s", s.UnitTyp.Typ())
 
 }
 
+// Len returns the bits the array occupies in storage, elements up to 16 bytes are packed without
+// crossing slot boundaries, larger elements, arrays and structs start a new slot each
 func (s SolidityArray) Len() uint {
-	return uint(s.UnitLength) * s.UnitTyp.Len()
+	unitLength := s.UnitTyp.Len()
+	if isPackedUnit(s.UnitTyp) {
+		perSlot := uint64(256 / unitLength)
+		return uint((s.UnitLength+perSlot-1)/perSlot) * 256
+	}
+	return uint(s.UnitLength*storageSlots(s.UnitTyp)) * 256
 }
 
 func (s SolidityArray) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityArray) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityStruct struct {
 	SlotIndex common.Hash
 	// field name and value mapping
 	FiledValueMap map[string]Variable
+
+	// NumberOfBytes is the storage size from the layout, always a multiple of 32
+	NumberOfBytes uint64 `json:"number_of_bytes"`
 }
 
 func (s SolidityStruct) Typ() SolidityTyp {
 	return StructTy
 }
 
-func (s SolidityStruct) Value(f GetValueStorageAtFunc) interface{} {
+func (s SolidityStruct) Value(f GetValueStorageAtFunc) (interface{}, error) {
 	return StructValue{
 		baseSlotIndex: s.SlotIndex,
 		filedValueMap: s.FiledValueMap,
 		f:             f,
-	}
+	}, nil
 
 }
 
+// Len returns the bits the struct occupies in storage, padding included
 func (s SolidityStruct) Len() uint {
-	var length uint
+	if s.NumberOfBytes > 0 {
+		return uint(s.NumberOfBytes * 8)
+	}
+	// no layout size, the struct ends with the slot of the member reaching the furthest
+	var slots uint64
 	for _, v := range s.FiledValueMap {
-		length += v.Len()
+		if end := v.Slot().Big().Uint64() + storageSlots(v); end > slots {
+			slots = end
+		}
 	}
-	return length
+	return uint(slots * 256)
 }
 
 func (s SolidityStruct) Slot() common.Hash {
 	return s.SlotIndex
 }
 
+func (s SolidityStruct) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
 type SolidityMapping struct {
 	SlotIndex common.Hash
 
@@ -560,14 +723,14 @@ func (s SolidityMapping) Typ() SolidityTyp {
 	return MappingTy
 }
 
-func (s SolidityMapping) Value(f GetValueStorageAtFunc) interface{} {
+func (s SolidityMapping) Value(f GetValueStorageAtFunc) (interface{}, error) {
 	m := MappingValue{
 		baseSlotIndex: s.SlotIndex,
 		keyTyp:        s.KeyTyp,
 		valueTyp:      s.ValueTyp,
 		f:             f,
 	}
-	return m
+	return m, nil
 
 }
 
@@ -578,3 +741,8 @@ func (s SolidityMapping) Len() uint {
 func (s SolidityMapping) Slot() common.Hash {
 	return s.SlotIndex
 }
+
+func (s SolidityMapping) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
@@ -1,13 +1,28 @@
 package storagescan
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 )
 
+// ErrIndexOutOfRange is returned when reading past the end of a slice or array
+var ErrIndexOutOfRange = errors.New("index out of range")
+
 type SliceArrayValueI interface {
-	Index(i uint64) interface{}
+	// Index returns element i, an index past the end returns ErrIndexOutOfRange
+	Index(i uint64) (interface{}, error)
+
+	// Len returns the number of elements
+	Len() uint64
+
+	// Range calls fn for every element in order until fn returns false, it stops at the first failed read
+	Range(fn func(i uint64, v interface{}) bool) error
+
+	// Slice returns the elements from index from up to, but not including, index to
+	Slice(from, to uint64) ([]interface{}, error)
+
 	String() string
 }
 
@@ -18,34 +33,49 @@ type UintSliceValue struct {
 
 	length uint64
 
-	f GetValueStorageAtFunc
+	f       GetValueStorageAtFunc
+	batcher *slotBatcher
 }
 
-func (s UintSliceValue) Index(i uint64) interface{} {
-
-	beginBit := i * uint64(s.uintBitLength)
+func (s UintSliceValue) Index(i uint64) (interface{}, error) {
 
-	offset := beginBit % 256
-
-	slotIndex := new(big.Int)
-	slotIndex.Add(s.slotIndex.Big(), big.NewInt(int64(beginBit/256)))
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
+	}
+	slotIndex, offset := packedPosition(s.slotIndex, i, s.uintBitLength)
 
 	su := SolidityUint{
 		Length:    s.uintBitLength,
-		Offset:    uint(offset),
-		SlotIndex: common.BigToHash(slotIndex),
+		Offset:    offset,
+		SlotIndex: slotIndex,
 	}
-	return su.Value(s.f)
+	return decodeBatched(&su, s.f, s.batcher)
 
 }
 
 func (s UintSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < s.length; i++ {
-		values = append(values, s.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(s, s.batcher)
+}
 
+func (s UintSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
+
+func (s UintSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s UintSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s UintSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s UintSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
 }
 
 type IntSliceValue struct {
@@ -55,116 +85,230 @@ type IntSliceValue struct {
 
 	length uint64
 
-	f GetValueStorageAtFunc
+	f       GetValueStorageAtFunc
+	batcher *slotBatcher
 }
 
-func (s IntSliceValue) Index(i uint64) interface{} {
-
-	beginBit := i * uint64(s.uintBitLength)
-
-	offset := beginBit % 256
+func (s IntSliceValue) Index(i uint64) (interface{}, error) {
 
-	slotIndex := new(big.Int)
-	slotIndex.Add(s.slotIndex.Big(), big.NewInt(int64(beginBit/256)))
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
+	}
+	slotIndex, offset := packedPosition(s.slotIndex, i, s.uintBitLength)
 
 	si := SolidityInt{
 		Length:    s.uintBitLength,
-		Offset:    uint(offset),
-		SlotIndex: common.BigToHash(slotIndex),
+		Offset:    offset,
+		SlotIndex: slotIndex,
 	}
-	return si.Value(s.f)
+	return decodeBatched(&si, s.f, s.batcher)
 
 }
 
 func (s IntSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < s.length; i++ {
-		values = append(values, s.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(s, s.batcher)
+}
+
+func (s IntSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
 
+func (s IntSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s IntSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s IntSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s IntSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
 }
 
 type StringSliceValue struct {
 	slotIndex common.Hash
 	length    uint64
 	f         GetValueStorageAtFunc
+	batcher   *slotBatcher
 }
 
-func (s StringSliceValue) Index(i uint64) interface{} {
+func (s StringSliceValue) Index(i uint64) (interface{}, error) {
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
+	}
 	slotIndex := new(big.Int)
 	slotIndex.Add(s.slotIndex.Big(), big.NewInt(int64(i)))
 	ss := SolidityString{
 		SlotIndex: common.BigToHash(slotIndex)}
-	return ss.Value(s.f)
+	return decodeBatched(&ss, s.f, s.batcher)
 
 }
 
 func (s StringSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < s.length; i++ {
-		values = append(values, s.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(s, s.batcher)
+}
 
+func (s StringSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
 }
 
-type BoolSliceValue struct {
+func (s StringSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s StringSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s StringSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s StringSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
+}
+
+type DynamicBytesSliceValue struct {
 	slotIndex common.Hash
 	length    uint64
 	f         GetValueStorageAtFunc
+	batcher   *slotBatcher
 }
 
-func (b BoolSliceValue) Index(i uint64) interface{} {
+func (s DynamicBytesSliceValue) Index(i uint64) (interface{}, error) {
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
+	}
+	slotIndex := new(big.Int)
+	slotIndex.Add(s.slotIndex.Big(), big.NewInt(int64(i)))
+	sb := SolidityDynamicBytes{
+		SlotIndex: common.BigToHash(slotIndex)}
+	return decodeBatched(&sb, s.f, s.batcher)
 
-	beginBit := i * uint64(8)
+}
 
-	offset := beginBit % 256
+func (s DynamicBytesSliceValue) String() string {
+	return sliceString(s, s.batcher)
+}
 
-	slotIndex := new(big.Int)
-	slotIndex.Add(b.slotIndex.Big(), big.NewInt(int64(beginBit/256)))
+func (s DynamicBytesSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
+
+func (s DynamicBytesSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s DynamicBytesSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s DynamicBytesSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s DynamicBytesSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
+}
+
+type BoolSliceValue struct {
+	slotIndex common.Hash
+	length    uint64
+	f         GetValueStorageAtFunc
+	batcher   *slotBatcher
+}
+
+func (b BoolSliceValue) Index(i uint64) (interface{}, error) {
+
+	if err := checkIndex(i, b.length); err != nil {
+		return nil, err
+	}
+	slotIndex, offset := packedPosition(b.slotIndex, i, 8)
 
 	sb := SolidityBool{
-		SlotIndex: common.BigToHash(slotIndex),
-		Offset:    uint(offset),
+		SlotIndex: slotIndex,
+		Offset:    offset,
 	}
 
-	return sb.Value(b.f)
+	return decodeBatched(&sb, b.f, b.batcher)
 
 }
 
 func (b BoolSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < b.length; i++ {
-		values = append(values, b.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(b, b.batcher)
+}
+
+func (b BoolSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(b)
+}
+
+func (b BoolSliceValue) Len() uint64 {
+	return b.length
+}
+
+func (b BoolSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(b, b.batcher, fn)
+}
 
+func (b BoolSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(b, b.batcher, from, to)
+}
+
+func (b BoolSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	b.f, b.batcher = f, batcher
+	return b
 }
 
 type AddressSliceValue struct {
 	slotIndex common.Hash
 	length    uint64
 	f         GetValueStorageAtFunc
+	batcher   *slotBatcher
 }
 
-func (a AddressSliceValue) Index(i uint64) interface{} {
+func (a AddressSliceValue) Index(i uint64) (interface{}, error) {
 
+	if err := checkIndex(i, a.length); err != nil {
+		return nil, err
+	}
 	slotIndex := new(big.Int)
 	slotIndex.Add(a.slotIndex.Big(), big.NewInt(int64(i)))
 	sa := SolidityAddress{
 		SlotIndex: common.BigToHash(slotIndex)}
-	return sa.Value(a.f)
+	return decodeBatched(&sa, a.f, a.batcher)
 
 }
 
 func (a AddressSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < a.length; i++ {
-		values = append(values, a.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(a, a.batcher)
+}
 
+func (a AddressSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(a)
+}
+
+func (a AddressSliceValue) Len() uint64 {
+	return a.length
+}
+
+func (a AddressSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(a, a.batcher, fn)
+}
+
+func (a AddressSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(a, a.batcher, from, to)
+}
+
+func (a AddressSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	a.f, a.batcher = f, batcher
+	return a
 }
 
 type BytesSliceValue struct {
@@ -174,72 +318,244 @@ type BytesSliceValue struct {
 
 	length uint64
 
-	f GetValueStorageAtFunc
+	f       GetValueStorageAtFunc
+	batcher *slotBatcher
 }
 
-func (b BytesSliceValue) Index(i uint64) interface{} {
-
-	beginBit := i * uint64(b.uintBitLength)
+func (b BytesSliceValue) Index(i uint64) (interface{}, error) {
 
-	offset := beginBit % 256
-
-	slotIndex := new(big.Int)
-	slotIndex.Add(b.slotIndex.Big(), big.NewInt(int64(beginBit/256)))
+	if err := checkIndex(i, b.length); err != nil {
+		return nil, err
+	}
+	slotIndex, offset := packedPosition(b.slotIndex, i, b.uintBitLength)
 
 	sb := SolidityBytes{
-		SlotIndex: common.BigToHash(slotIndex),
+		SlotIndex: slotIndex,
 		Length:    b.uintBitLength,
-		Offset:    uint(offset),
+		Offset:    offset,
 	}
-	return sb.Value(b.f)
+	return decodeBatched(&sb, b.f, b.batcher)
 }
 
 func (b BytesSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < b.length; i++ {
-		values = append(values, b.Index(i))
-	}
-	return fmt.Sprintf("%v", values)
+	return sliceString(b, b.batcher)
+}
+
+func (b BytesSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(b)
+}
 
+func (b BytesSliceValue) Len() uint64 {
+	return b.length
+}
+
+func (b BytesSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(b, b.batcher, fn)
+}
+
+func (b BytesSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(b, b.batcher, from, to)
+}
+
+func (b BytesSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	b.f, b.batcher = f, batcher
+	return b
 }
 
 type StructSliceValue struct {
-	slotIndex       common.Hash
-	filedValueMap   map[string]Variable
-	length          uint64
-	f               GetValueStorageAtFunc
+	slotIndex     common.Hash
+	filedValueMap map[string]Variable
+	length        uint64
+	f             GetValueStorageAtFunc
+	batcher       *slotBatcher
+	// structSlotCount is the number of slots of one struct, from the layout's numberOfBytes
 	structSlotCount uint64
 }
 
-func (s StructSliceValue) maxSlotCount() uint64 {
-	if s.structSlotCount > 0 {
-		return s.structSlotCount
-	}
-	var max uint64
-	for _, fieldValue := range s.filedValueMap {
-		if fieldValue.Slot().Big().Uint64() > max {
-			max = fieldValue.Slot().Big().Uint64()
-		}
+func (s StructSliceValue) Index(i uint64) (interface{}, error) {
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
 	}
-	s.structSlotCount = max
-	return max
-}
-
-func (s StructSliceValue) Index(i uint64) interface{} {
-	slotIndex := new(big.Int)
-	slotIndex.Add(s.slotIndex.Big(), big.NewInt(int64(i)*int64(s.maxSlotCount()+1)))
+	slotIndex := new(big.Int).SetUint64(i)
+	slotIndex.Mul(slotIndex, new(big.Int).SetUint64(s.structSlotCount)).Add(slotIndex, s.slotIndex.Big())
 	ss := SolidityStruct{
 		SlotIndex:     common.BigToHash(slotIndex),
 		FiledValueMap: s.filedValueMap,
 	}
-	return ss.Value(s.f)
+	return decodeBatched(&ss, s.f, s.batcher)
 }
 
 func (s StructSliceValue) String() string {
-	values := make([]interface{}, 0)
-	for i := uint64(0); i < s.length; i++ {
-		values = append(values, s.Index(i))
+	return sliceString(s, s.batcher)
+}
+
+func (s StructSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
+
+func (s StructSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s StructSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s StructSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s StructSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
+}
+
+// VariableSliceValue holds elements that start a new slot each and decode to their own value,
+// e.g. the rows of uint256[3][4] or uint8[][], the elements are nested SliceArrayValueI or MappingValueI
+type VariableSliceValue struct {
+	slotIndex common.Hash
+
+	unitTyp Variable
+
+	// unitSlots is the number of slots of one element
+	unitSlots uint64
+
+	length uint64
+
+	f       GetValueStorageAtFunc
+	batcher *slotBatcher
+}
+
+func (s VariableSliceValue) Index(i uint64) (interface{}, error) {
+	if err := checkIndex(i, s.length); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("%v", values)
+	slotIndex := new(big.Int).SetUint64(i)
+	slotIndex.Mul(slotIndex, new(big.Int).SetUint64(s.unitSlots)).Add(slotIndex, s.slotIndex.Big())
+	return decodeBatched(s.unitTyp.Rebase(common.BigToHash(slotIndex)), s.f, s.batcher)
+}
+
+func (s VariableSliceValue) String() string {
+	return sliceString(s, s.batcher)
+}
+
+func (s VariableSliceValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
+
+func (s VariableSliceValue) Len() uint64 {
+	return s.length
+}
+
+func (s VariableSliceValue) Range(fn func(i uint64, v interface{}) bool) error {
+	return rangeSlice(s, s.batcher, fn)
+}
+
+func (s VariableSliceValue) Slice(from, to uint64) ([]interface{}, error) {
+	return sliceElements(s, s.batcher, from, to)
+}
+
+func (s VariableSliceValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
+}
+
+// maxStringElements bounds the elements formatted by String, the length of a slice is read from storage
+// and a wrong layout may read a huge one
+const maxStringElements = 100
+
+// prefetchPage is the number of elements whose slots are fetched in one batch by Range, Slice and String
+const prefetchPage = 100
 
+// batchedSlice is a slice value whose element reads can be batched
+type batchedSlice interface {
+	SliceArrayValueI
+	batchedValue
+}
+
+// sliceString formats the first elements of s, a failed read is reported in place of the values
+func sliceString(s batchedSlice, b *slotBatcher) string {
+	length := s.Len()
+	shown := length
+	if shown > maxStringElements {
+		shown = maxStringElements
+	}
+	var values []interface{}
+	err := rangeElements(s, b, 0, shown, true, func(i uint64, v interface{}) bool {
+		values = append(values, v)
+		return true
+	})
+	if err != nil {
+		return fmt.Sprintf("<error: %v>", err)
+	}
+	str := fmt.Sprintf("%v", values)
+	if shown < length {
+		str = fmt.Sprintf("%s ...%d more]", str[:len(str)-1], length-shown)
+	}
+	return str
+}
+
+func checkIndex(i, length uint64) error {
+	if i >= length {
+		return fmt.Errorf("%w: index %d, length %d", ErrIndexOutOfRange, i, length)
+	}
+	return nil
+}
+
+func rangeSlice(s batchedSlice, b *slotBatcher, fn func(i uint64, v interface{}) bool) error {
+	return rangeElements(s, b, 0, s.Len(), false, fn)
+}
+
+func sliceElements(s batchedSlice, b *slotBatcher, from, to uint64) ([]interface{}, error) {
+	if from > to || to > s.Len() {
+		return nil, fmt.Errorf("%w: slice [%d:%d], length %d", ErrIndexOutOfRange, from, to, s.Len())
+	}
+	// no capacity from the length, it is read from storage
+	var values []interface{}
+	err := rangeElements(s, b, from, to, false, func(i uint64, v interface{}) bool {
+		values = append(values, v)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// rangeElements calls fn for the elements from index from up to to until fn returns false, with a batcher
+// the slots of every page of elements are fetched in one batch, along with the slots formatting the
+// elements reads when expand is set
+func rangeElements(s batchedSlice, b *slotBatcher, from, to uint64, expand bool, fn func(i uint64, v interface{}) bool) error {
+	for begin := from; begin < to; {
+		end := to
+		if to-begin > prefetchPage {
+			end = begin + prefetchPage
+		}
+		if b != nil {
+			b.release()
+			err := b.prefetch(func(plan GetValueStorageAtFunc) {
+				planned := s.withReader(plan, nil).(SliceArrayValueI)
+				for i := begin; i < end; i++ {
+					v, err := planned.Index(i)
+					if err != nil || expand && !expandValue(v) {
+						return
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for i := begin; i < end; i++ {
+			v, err := s.Index(i)
+			if err != nil {
+				return err
+			}
+			if !fn(i, v) {
+				return nil
+			}
+		}
+		begin = end
+	}
+	return nil
 }
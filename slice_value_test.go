@@ -0,0 +1,155 @@
+package storagescan
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const uintSliceLayout = `{"storage":[{"label":"big","offset":0,"slot":"0","type":"t_array(t_uint256)dyn_storage"}],
+"types":{"t_array(t_uint256)dyn_storage":{"base":"t_uint256","encoding":"dynamic_array","label":"uint256[]","numberOfBytes":"32"},
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"}}}`
+
+func TestSliceHugeLength(t *testing.T) {
+	length := uint64(1) << 40
+	data := crypto.Keccak256Hash(common.Hash{}.Bytes()).Big()
+	storage := map[common.Hash]common.Hash{
+		{}: common.BigToHash(new(big.Int).SetUint64(length)),
+	}
+	for i := int64(0); i < 3; i++ {
+		storage[common.BigToHash(new(big.Int).Add(data, big.NewInt(i)))] = common.BigToHash(big.NewInt(i + 10))
+	}
+	reader := NewSnapshotReader(common.Address{}, storage)
+	c, err := NewContractFromLayout(common.Address{}, reader, uintSliceLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.GetVariableValue("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := value.(SliceArrayValueI)
+	if s.Len() != length {
+		t.Fatalf("Len() = %d, want %d", s.Len(), length)
+	}
+
+	str := s.String()
+	if !strings.HasPrefix(str, "[10 11 12 0 ") || !strings.HasSuffix(str, fmt.Sprintf(" ...%d more]", length-maxStringElements)) {
+		t.Errorf("String() = %s", str)
+	}
+	page, err := s.Slice(1, 3)
+	if err != nil || fmt.Sprint(page) != "[11 12]" {
+		t.Errorf("Slice(1, 3) = %v, %v", page, err)
+	}
+	if _, err = s.Index(length); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("Index(%d) error = %v, want ErrIndexOutOfRange", length, err)
+	}
+	if _, err = s.Slice(length-1, length+1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("Slice past the end error = %v, want ErrIndexOutOfRange", err)
+	}
+}
+
+// uint256[3][4] grid; uint8[][] bytesList; string[][2] names; Entity[][] groups; uint8[2][3] small;
+// struct Entity { uint128 id; uint64 kind; string name; }
+const nestedArrayLayout = `{"storage":[
+{"label":"grid","offset":0,"slot":"0","type":"t_array(t_array(t_uint256)3_storage)4_storage"},
+{"label":"bytesList","offset":0,"slot":"12","type":"t_array(t_array(t_uint8)dyn_storage)dyn_storage"},
+{"label":"names","offset":0,"slot":"13","type":"t_array(t_array(t_string_storage)dyn_storage)2_storage"},
+{"label":"groups","offset":0,"slot":"15","type":"t_array(t_array(t_struct(Entity)9_storage)dyn_storage)dyn_storage"},
+{"label":"small","offset":0,"slot":"16","type":"t_array(t_array(t_uint8)2_storage)3_storage"}],
+"types":{
+"t_uint8":{"encoding":"inplace","label":"uint8","numberOfBytes":"1"},
+"t_uint64":{"encoding":"inplace","label":"uint64","numberOfBytes":"8"},
+"t_uint128":{"encoding":"inplace","label":"uint128","numberOfBytes":"16"},
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_string_storage":{"encoding":"bytes","label":"string","numberOfBytes":"32"},
+"t_array(t_uint256)3_storage":{"base":"t_uint256","encoding":"inplace","label":"uint256[3]","numberOfBytes":"96"},
+"t_array(t_array(t_uint256)3_storage)4_storage":{"base":"t_array(t_uint256)3_storage","encoding":"inplace","label":"uint256[3][4]","numberOfBytes":"384"},
+"t_array(t_uint8)dyn_storage":{"base":"t_uint8","encoding":"dynamic_array","label":"uint8[]","numberOfBytes":"32"},
+"t_array(t_array(t_uint8)dyn_storage)dyn_storage":{"base":"t_array(t_uint8)dyn_storage","encoding":"dynamic_array","label":"uint8[][]","numberOfBytes":"32"},
+"t_array(t_string_storage)dyn_storage":{"base":"t_string_storage","encoding":"dynamic_array","label":"string[]","numberOfBytes":"32"},
+"t_array(t_array(t_string_storage)dyn_storage)2_storage":{"base":"t_array(t_string_storage)dyn_storage","encoding":"inplace","label":"string[][2]","numberOfBytes":"64"},
+"t_struct(Entity)9_storage":{"encoding":"inplace","label":"struct Entity","numberOfBytes":"64","members":[
+{"label":"id","offset":0,"slot":"0","type":"t_uint128"},
+{"label":"kind","offset":16,"slot":"0","type":"t_uint64"},
+{"label":"name","offset":0,"slot":"1","type":"t_string_storage"}]},
+"t_array(t_struct(Entity)9_storage)dyn_storage":{"base":"t_struct(Entity)9_storage","encoding":"dynamic_array","label":"struct Entity[]","numberOfBytes":"32"},
+"t_array(t_array(t_struct(Entity)9_storage)dyn_storage)dyn_storage":{"base":"t_array(t_struct(Entity)9_storage)dyn_storage","encoding":"dynamic_array","label":"struct Entity[][]","numberOfBytes":"32"},
+"t_array(t_uint8)2_storage":{"base":"t_uint8","encoding":"inplace","label":"uint8[2]","numberOfBytes":"32"},
+"t_array(t_array(t_uint8)2_storage)3_storage":{"base":"t_array(t_uint8)2_storage","encoding":"inplace","label":"uint8[2][3]","numberOfBytes":"96"}}}`
+
+func TestNestedArrays(t *testing.T) {
+	st := testStorage{}
+	// grid[i][j] = 10*i + j, every uint256[3] takes three slots
+	for i := uint64(0); i < 4; i++ {
+		for j := uint64(0); j < 3; j++ {
+			st[slotOf(i*3+j)] = slotOf(10*i + j)
+		}
+	}
+	// bytesList = [[1, 2], [], 40 elements counting from 1 packed 32 per slot]
+	st[slotOf(12)] = slotOf(3)
+	st[dataSlot(slotOf(12), 0)] = slotOf(2)
+	st[dataSlot(dataSlot(slotOf(12), 0), 0)] = common.HexToHash("0x0201")
+	st[dataSlot(slotOf(12), 2)] = slotOf(40)
+	for k := 0; k < 40; k++ {
+		h := st[dataSlot(dataSlot(slotOf(12), 2), uint64(k/32))]
+		h[31-k%32] = byte(k + 1)
+		st[dataSlot(dataSlot(slotOf(12), 2), uint64(k/32))] = h
+	}
+	// names = [[], ["a", a name longer than a slot]]
+	st[slotOf(14)] = slotOf(2)
+	st.putString(dataSlot(slotOf(14), 0), "a")
+	st.putString(dataSlot(slotOf(14), 1), strings.Repeat("b", 40))
+	// groups = [[], [{7, 1, "seven"}, {8, 2, "eight"}]]
+	st[slotOf(15)] = slotOf(2)
+	group := dataSlot(slotOf(15), 1)
+	st[group] = slotOf(2)
+	st[dataSlot(group, 0)] = entityWord(7, 1)
+	st.putString(dataSlot(group, 1), "seven")
+	st[dataSlot(group, 2)] = entityWord(8, 2)
+	st.putString(dataSlot(group, 3), "eight")
+	// small = [[1, 2], [3, 4], [5, 6]], every uint8[2] starts a new slot
+	st[slotOf(16)] = common.HexToHash("0x0201")
+	st[slotOf(17)] = common.HexToHash("0x0403")
+	st[slotOf(18)] = common.HexToHash("0x0605")
+
+	checkQueries(t, nestedArrayLayout, st, []queryTest{
+		{path: "grid", want: "[[0 1 2] [10 11 12] [20 21 22] [30 31 32]]"},
+		{path: "grid[2][1]", want: "21"},
+		{path: "grid[3][2]", want: "32"},
+		{path: "bytesList[0]", want: "[1 2]"},
+		{path: "bytesList[1]", want: "[]"},
+		{path: "bytesList[2][0]", want: "1"},
+		{path: "bytesList[2][31]", want: "32"},
+		{path: "bytesList[2][32]", want: "33"},
+		{path: "bytesList[2][39]", want: "40"},
+		{path: "names[0]", want: "[]"},
+		{path: "names[1][0]", want: "a"},
+		{path: "names[1][1]", want: strings.Repeat("b", 40)},
+		{path: "groups[0]", want: "[]"},
+		{path: "groups[1][0].id", want: "7"},
+		{path: "groups[1][0].name", want: "seven"},
+		{path: "groups[1][1].kind", want: "2"},
+		{path: "groups[1][1].name", want: "eight"},
+		{path: "small", want: "[[1 2] [3 4] [5 6]]"},
+		{path: "small[2][1]", want: "6"},
+	})
+	c, err := NewContractFromLayout(common.Address{}, NewSnapshotReader(common.Address{}, st), nestedArrayLayout)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	for path, want := range map[string]uint64{"grid": 4, "grid[0]": 3, "bytesList": 3, "bytesList[2]": 40, "names": 2, "names[1]": 2, "groups[1]": 2} {
+		v, err := c.Query(path)
+		if err != nil {
+			t.Fatalf("query %s error: %v", path, err)
+		}
+		if got := v.(SliceArrayValueI).Len(); got != want {
+			t.Errorf("len(%s) = %d, want %d", path, got, want)
+		}
+	}
+}
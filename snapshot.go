@@ -0,0 +1,177 @@
+package storagescan
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"os"
+	"strings"
+)
+
+// SnapshotReader serves the storage of one contract from memory, it needs no node and ignores the block
+// slots missing from the snapshot read as zero, like unset storage
+type SnapshotReader struct {
+	address common.Address
+
+	storage map[common.Hash]common.Hash
+}
+
+// NewSnapshotReader returns a reader over storage, the map must not be modified afterwards
+func NewSnapshotReader(address common.Address, storage map[common.Hash]common.Hash) *SnapshotReader {
+	return &SnapshotReader{
+		address: address,
+		storage: storage,
+	}
+}
+
+// LoadSnapshot reads the storage of address from a JSON snapshot, accepted layouts are:
+//   - a bare {"slot": "value"} map
+//   - {"storage": {...}} as returned by debug_storageRangeAt, entries may be {"key": slot, "value": value}
+//   - {"accounts": {"address": {"storage": {...}}}} as written by geth debug_dumpBlock or anvil dump_state
+//   - {"address": {"storage": {...}}} as written by older anvil versions
+func LoadSnapshot(r io.Reader, address common.Address) (*SnapshotReader, error) {
+	var top map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&top); err != nil {
+		return nil, fmt.Errorf("parse snapshot error: %w", err)
+	}
+
+	storageJson, err := findSnapshotStorage(top, address)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := parseSnapshotStorage(storageJson)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshotReader(address, storage), nil
+}
+
+// LoadSnapshotFile is LoadSnapshot on the content of the file at path
+func LoadSnapshotFile(path string, address common.Address) (*SnapshotReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadSnapshot(f, address)
+}
+
+type snapshotAccount struct {
+	Storage map[string]json.RawMessage `json:"storage"`
+}
+
+func findSnapshotStorage(top map[string]json.RawMessage, address common.Address) (map[string]json.RawMessage, error) {
+	if raw, ok := top["accounts"]; ok {
+		var accounts map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &accounts); err != nil {
+			return nil, fmt.Errorf("parse snapshot accounts error: %w", err)
+		}
+		return findSnapshotAccount(accounts, address)
+	}
+	if raw, ok := top["storage"]; ok {
+		var storage map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &storage); err != nil {
+			return nil, fmt.Errorf("parse snapshot storage error: %w", err)
+		}
+		return storage, nil
+	}
+	for k, raw := range top {
+		if common.IsHexAddress(k) && strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+			return findSnapshotAccount(top, address)
+		}
+	}
+	return top, nil
+}
+
+func findSnapshotAccount(accounts map[string]json.RawMessage, address common.Address) (map[string]json.RawMessage, error) {
+	for k, raw := range accounts {
+		if !common.IsHexAddress(k) || common.HexToAddress(k) != address {
+			continue
+		}
+		var account snapshotAccount
+		if err := json.Unmarshal(raw, &account); err != nil {
+			return nil, fmt.Errorf("parse snapshot account %s error: %w", k, err)
+		}
+		return account.Storage, nil
+	}
+	return nil, fmt.Errorf("snapshot does not contain account %s", address.Hex())
+}
+
+// parseSnapshotStorage decodes slot and value hex strings, with or without 0x prefix and leading zeroes,
+// an entry that is not hex or wider than 32 bytes fails the whole snapshot
+func parseSnapshotStorage(storageJson map[string]json.RawMessage) (map[common.Hash]common.Hash, error) {
+	storage := make(map[common.Hash]common.Hash, len(storageJson))
+	for k, raw := range storageJson {
+		slot, value := k, ""
+		if err := json.Unmarshal(raw, &value); err != nil {
+			// debug_storageRangeAt entry, the map key is the hashed slot
+			var entry struct {
+				Key   *string `json:"key"`
+				Value string  `json:"value"`
+			}
+			if err = json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("parse snapshot slot %s error: %w", k, err)
+			}
+			if entry.Key == nil {
+				return nil, fmt.Errorf("snapshot slot %s has no preimage", k)
+			}
+			slot, value = *entry.Key, entry.Value
+		}
+		slotHash, err := decodeSnapshotWord(slot)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot slot %s: %w", k, err)
+		}
+		valueHash, err := decodeSnapshotWord(value)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot value of slot %s: %w", k, err)
+		}
+		storage[slotHash] = valueHash
+	}
+	return storage, nil
+}
+
+// decodeSnapshotWord decodes a hex word of at most 32 bytes, the 0x prefix is optional and an odd number
+// of digits is read with a leading zero, malformed hex is an error rather than a zero word
+func decodeSnapshotWord(word string) (common.Hash, error) {
+	digits := word
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits = digits[2:]
+	}
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("invalid hex %q: %v", word, err)
+	}
+	if len(b) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("hex %q is longer than 32 bytes", word)
+	}
+	return common.BytesToHash(b), nil
+}
+
+func (s *SnapshotReader) StorageAt(ctx context.Context, account common.Address, slot common.Hash, block *rpc.BlockNumberOrHash) ([]byte, error) {
+	if account != s.address {
+		return nil, fmt.Errorf("snapshot does not contain account %s", account.Hex())
+	}
+	value := s.storage[slot]
+	return value.Bytes(), nil
+}
+
+// StorageAtFunc returns the in-memory GetValueStorageAtFunc of the snapshot
+func (s *SnapshotReader) StorageAtFunc() GetValueStorageAtFunc {
+	return func(slot common.Hash) ([]byte, error) {
+		return s.StorageAt(context.Background(), s.address, slot, nil)
+	}
+}
+
+// Len returns the number of slots in the snapshot
+func (s *SnapshotReader) Len() int {
+	return len(s.storage)
+}
+
+func (s *SnapshotReader) Close() {}
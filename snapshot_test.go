@@ -0,0 +1,58 @@
+package storagescan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLoadSnapshot(t *testing.T) {
+	address := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	wide := "0x" + strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{name: "bare map", json: `{"0x1":"0x2a","02":"` + wide + `"}`},
+		{name: "storage", json: `{"storage":{"0x01":"0x2A","0X2":"` + wide[2:] + `"}}`},
+		{name: "storage range entries", json: `{"storage":{
+"0xb10e2d527612073b26eecdfd717e6a320cf44b4afac2b0732d9fcbe2b7fa0cf6":{"key":"0x01","value":"0x2a"},
+"0x405787fa12a823e0f2b7631cc41b3ba8828b3321ca811111fa75cd3aa3bb5ace":{"key":"0x0000000000000000000000000000000000000000000000000000000000000002","value":"` + wide + `"}},
+"nextKey":null}`},
+		{name: "accounts", json: `{"root":"0x00","accounts":{
+"0x00000000000000000000000000000000000000bb":{"storage":{"0x01":"0x01"}},
+"0x00000000000000000000000000000000000000AA":{"balance":"0","nonce":1,"storage":{"0x01":"0x2a","0x02":"` + wide + `"}}}}`},
+		{name: "address keyed", json: `{"0x00000000000000000000000000000000000000aa":{"nonce":1,"code":"0x","storage":{"0x01":"0x2a","0x02":"` + wide + `"}}}`},
+
+		{name: "not json", json: `{"0x01":`, err: "parse snapshot error"},
+		{name: "bad value", json: `{"0x01":"0xzz"}`, err: `snapshot value of slot 0x01: invalid hex "0xzz"`},
+		{name: "bad slot", json: `{"storage":{"0xzz":"0x01"}}`, err: `snapshot slot 0xzz: invalid hex "0xzz"`},
+		{name: "wide value", json: `{"0x01":"0x01` + wide[2:] + `"}`, err: "snapshot value of slot 0x01: hex \"0x01ab"},
+		{name: "wide slot", json: `{"` + wide + `00":"0x01"}`, err: "longer than 32 bytes"},
+		{name: "bad entry value", json: `{"storage":{"0xb1":{"key":"0x01","value":"0x2g"}}}`, err: "snapshot value of slot 0xb1: invalid hex"},
+		{name: "entry without preimage", json: `{"storage":{"0xb1":{"value":"0x2a"}}}`, err: "snapshot slot 0xb1 has no preimage"},
+		{name: "missing account", json: `{"accounts":{"0x00000000000000000000000000000000000000bb":{"storage":{}}}}`, err: "snapshot does not contain account"},
+	}
+	for _, tt := range tests {
+		snapshot, err := LoadSnapshot(strings.NewReader(tt.json), address)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.name, err)
+			continue
+		}
+		f := snapshot.StorageAtFunc()
+		for slot, want := range map[uint64]common.Hash{1: slotOf(42), 2: common.HexToHash(wide), 3: {}} {
+			got, err := f(slotOf(slot))
+			if err != nil || common.BytesToHash(got) != want {
+				t.Errorf("%s: slot %d = %x, %v, want %s", tt.name, slot, got, err, want.Hex())
+			}
+		}
+	}
+}
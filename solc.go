@@ -0,0 +1,158 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrContractNotFound is returned when a compiler output holds no contract of the requested name
+var ErrContractNotFound = errors.New("contract not found")
+
+// ErrAmbiguousContract is returned when a contract name matches several contracts of a compiler output
+var ErrAmbiguousContract = errors.New("ambiguous contract name")
+
+type solcError struct {
+	Severity         string `json:"severity"`
+	FormattedMessage string `json:"formattedMessage"`
+	Message          string `json:"message"`
+}
+
+// SolcStorageLayout returns the storage layout of contract name from the output of
+// solc --standard-json, where layouts are under contracts[file][Name].storageLayout, or of
+// solc --combined-json storage-layout, where they are under contracts["file:Name"]["storage-layout"].
+// name is either "file:Name" or "Name", it may be empty when the output holds a single contract,
+// the available contracts are listed when it does not match exactly one
+func SolcStorageLayout(output string, name string) (string, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(output), &top); err != nil {
+		return "", fmt.Errorf("parse solc output error: %w", err)
+	}
+	if err := solcOutputError(top); err != nil {
+		return "", err
+	}
+
+	var layouts map[string]json.RawMessage
+	var err error
+	if _, ok := top["sources"]; !ok && (top["version"] != nil || top["sourceList"] != nil) {
+		layouts, err = combinedJsonLayouts(top["contracts"])
+	} else {
+		layouts, err = standardJsonLayouts(top["contracts"])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return selectLayout(layouts, name, "request storageLayout (--combined-json storage-layout) from solc")
+}
+
+// selectLayout returns the layout of the contract matching name, hint tells how to get a missing layout
+func selectLayout(layouts map[string]json.RawMessage, name string, hint string) (string, error) {
+	ids := make([]string, 0, len(layouts))
+	for id := range layouts {
+		ids = append(ids, id)
+	}
+	id, err := selectContract(ids, name)
+	if err != nil {
+		return "", err
+	}
+	layout := layouts[id]
+	if len(layout) == 0 || string(layout) == "null" {
+		return "", fmt.Errorf("contract %s has no storage layout, %s", id, hint)
+	}
+	return string(layout), nil
+}
+
+// ParseBySolcOutput parses the storage layout of contract name from the output of solc,
+// see SolcStorageLayout for the accepted outputs and names
+func (c *Contract) ParseBySolcOutput(output string, name string) error {
+	layout, err := SolcStorageLayout(output, name)
+	if err != nil {
+		return err
+	}
+	return c.ParseByStorageLayout(layout)
+}
+
+// solcOutputError returns the first error reported by a failed compilation
+func solcOutputError(top map[string]json.RawMessage) error {
+	raw, ok := top["errors"]
+	if !ok {
+		return nil
+	}
+	var solcErrors []solcError
+	if err := json.Unmarshal(raw, &solcErrors); err != nil {
+		return fmt.Errorf("parse solc errors error: %w", err)
+	}
+	for _, e := range solcErrors {
+		if e.Severity == "error" {
+			msg := e.FormattedMessage
+			if msg == "" {
+				msg = e.Message
+			}
+			return fmt.Errorf("solc compilation failed: %s", strings.TrimSpace(msg))
+		}
+	}
+	return nil
+}
+
+// standardJsonLayouts returns the layouts of a standard json output keyed by "file:Name"
+func standardJsonLayouts(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	var contracts map[string]map[string]struct {
+		StorageLayout json.RawMessage `json:"storageLayout"`
+	}
+	if err := json.Unmarshal(raw, &contracts); err != nil {
+		return nil, fmt.Errorf("parse solc contracts error: %w", err)
+	}
+	layouts := make(map[string]json.RawMessage)
+	for file, named := range contracts {
+		for name, contract := range named {
+			layouts[file+":"+name] = contract.StorageLayout
+		}
+	}
+	return layouts, nil
+}
+
+// combinedJsonLayouts returns the layouts of a combined json output keyed by "file:Name",
+// solc before 0.8.x writes every output as an encoded json string
+func combinedJsonLayouts(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	var contracts map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &contracts); err != nil {
+		return nil, fmt.Errorf("parse solc contracts error: %w", err)
+	}
+	layouts := make(map[string]json.RawMessage)
+	for id, outputs := range contracts {
+		layout := outputs["storage-layout"]
+		var encoded string
+		if err := json.Unmarshal(layout, &encoded); err == nil {
+			layout = json.RawMessage(encoded)
+		}
+		layouts[id] = layout
+	}
+	return layouts, nil
+}
+
+// selectContract returns the "file:Name" id matching name, which is either an id or a contract name
+func selectContract(ids []string, name string) (string, error) {
+	sort.Strings(ids)
+	var matches []string
+	for _, id := range ids {
+		if id == name {
+			return id, nil
+		}
+		if name == "" || id[strings.LastIndex(id, ":")+1:] == name {
+			matches = append(matches, id)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case name == "" && len(matches) > 1:
+		return "", fmt.Errorf("%w: no contract name given, available contracts: %s", ErrAmbiguousContract, strings.Join(ids, ", "))
+	case len(matches) == 0:
+		return "", fmt.Errorf("%w: %q, available contracts: %s", ErrContractNotFound, name, strings.Join(ids, ", "))
+	default:
+		return "", fmt.Errorf("%w: %q matches %s", ErrAmbiguousContract, name, strings.Join(matches, ", "))
+	}
+}
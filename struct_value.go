@@ -3,13 +3,11 @@ package storagescan
 import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
-	"math/big"
-	"reflect"
 	"strings"
 )
 
 type StructValueI interface {
-	Field(f string) interface{}
+	Field(f string) (interface{}, error)
 	String() string
 }
 
@@ -19,31 +17,44 @@ type StructValue struct {
 	filedValueMap map[string]Variable
 
 	f GetValueStorageAtFunc
+
+	batcher *slotBatcher
 }
 
-func (s StructValue) Field(fd string) interface{} {
+func (s StructValue) Field(fd string) (interface{}, error) {
 	filedValue, ok := s.filedValueMap[fd]
 	if !ok {
-		return nil
+		return nil, fmt.Errorf("struct field %s not found", fd)
 	}
 
-	oldSlot := filedValue.Slot()
-
-	slotIndex := new(big.Int)
-	slotIndex.Add(s.baseSlotIndex.Big(), filedValue.Slot().Big())
-
-	// convert the slotIndex to common.Hash and assign it to the SlotIndex field of filed Value.V, using reflection
-	reflect.ValueOf(filedValue).Elem().FieldByName("SlotIndex").Set(reflect.ValueOf(common.BigToHash(slotIndex)))
-	value := filedValue.Value(s.f)
-	reflect.ValueOf(filedValue).Elem().FieldByName("SlotIndex").Set(reflect.ValueOf(oldSlot))
-	return value
-
+	return decodeBatched(filedValue.Rebase(s.baseSlotIndex), s.f, s.batcher)
 }
 
 func (s StructValue) String() string {
+	if s.batcher != nil {
+		err := s.batcher.prefetch(func(plan GetValueStorageAtFunc) {
+			expandValue(s.withReader(plan, nil))
+		})
+		if err != nil {
+			return fmt.Sprintf("<error: %v>", err)
+		}
+	}
 	var fSting string
 	for filedName := range s.filedValueMap {
-		fSting += fmt.Sprintf("%v:%v ", filedName, s.Field(filedName))
+		value, err := s.Field(filedName)
+		if err != nil {
+			return fmt.Sprintf("<error: %v>", err)
+		}
+		fSting += fmt.Sprintf("%v:%v ", filedName, value)
 	}
 	return "struct{" + strings.TrimRight(fSting, " ") + "}"
 }
+
+func (s StructValue) MarshalJSON() ([]byte, error) {
+	return marshalValue(s)
+}
+
+func (s StructValue) withReader(f GetValueStorageAtFunc, batcher *slotBatcher) interface{} {
+	s.f, s.batcher = f, batcher
+	return s
+}
@@ -0,0 +1,100 @@
+package storagescan
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// struct Inner { uint8 a; uint256 b; }
+// struct Outer { uint32 x; uint16[5] arr; Inner inner; uint256[] dyn; mapping(uint256 => uint256) m; Inner[2] pair; uint8 tail; }
+// Outer o; Outer[] list; Inner[3] inners; uint8 last;
+const structMembersLayout = `{"storage":[
+{"label":"o","offset":0,"slot":"0","type":"t_struct(Outer)20_storage"},
+{"label":"list","offset":0,"slot":"11","type":"t_array(t_struct(Outer)20_storage)dyn_storage"},
+{"label":"inners","offset":0,"slot":"12","type":"t_array(t_struct(Inner)5_storage)3_storage"},
+{"label":"last","offset":0,"slot":"18","type":"t_uint8"}],
+"types":{
+"t_uint8":{"encoding":"inplace","label":"uint8","numberOfBytes":"1"},
+"t_uint16":{"encoding":"inplace","label":"uint16","numberOfBytes":"2"},
+"t_uint32":{"encoding":"inplace","label":"uint32","numberOfBytes":"4"},
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_array(t_uint16)5_storage":{"base":"t_uint16","encoding":"inplace","label":"uint16[5]","numberOfBytes":"32"},
+"t_array(t_uint256)dyn_storage":{"base":"t_uint256","encoding":"dynamic_array","label":"uint256[]","numberOfBytes":"32"},
+"t_mapping(t_uint256,t_uint256)":{"encoding":"mapping","key":"t_uint256","label":"mapping(uint256 => uint256)","numberOfBytes":"32","value":"t_uint256"},
+"t_struct(Inner)5_storage":{"encoding":"inplace","label":"struct Inner","numberOfBytes":"64","members":[
+{"label":"a","offset":0,"slot":"0","type":"t_uint8"},
+{"label":"b","offset":0,"slot":"1","type":"t_uint256"}]},
+"t_array(t_struct(Inner)5_storage)2_storage":{"base":"t_struct(Inner)5_storage","encoding":"inplace","label":"struct Inner[2]","numberOfBytes":"128"},
+"t_array(t_struct(Inner)5_storage)3_storage":{"base":"t_struct(Inner)5_storage","encoding":"inplace","label":"struct Inner[3]","numberOfBytes":"192"},
+"t_struct(Outer)20_storage":{"encoding":"inplace","label":"struct Outer","numberOfBytes":"352","members":[
+{"label":"x","offset":0,"slot":"0","type":"t_uint32"},
+{"label":"arr","offset":0,"slot":"1","type":"t_array(t_uint16)5_storage"},
+{"label":"inner","offset":0,"slot":"2","type":"t_struct(Inner)5_storage"},
+{"label":"dyn","offset":0,"slot":"4","type":"t_array(t_uint256)dyn_storage"},
+{"label":"m","offset":0,"slot":"5","type":"t_mapping(t_uint256,t_uint256)"},
+{"label":"pair","offset":0,"slot":"6","type":"t_array(t_struct(Inner)5_storage)2_storage"},
+{"label":"tail","offset":0,"slot":"10","type":"t_uint8"}]},
+"t_array(t_struct(Outer)20_storage)dyn_storage":{"base":"t_struct(Outer)20_storage","encoding":"dynamic_array","label":"struct Outer[]","numberOfBytes":"32"}}}`
+
+func TestStructMembers(t *testing.T) {
+	st := testStorage{}
+	st[slotOf(0)] = slotOf(5)
+	st[slotOf(1)] = common.HexToHash("0x00050004000300020001")
+	st[slotOf(2)] = slotOf(9)
+	st[slotOf(3)] = slotOf(10)
+	st[slotOf(4)] = slotOf(2)
+	st[dataSlot(slotOf(4), 0)] = slotOf(100)
+	st[dataSlot(slotOf(4), 1)] = slotOf(200)
+	st[crypto.Keccak256Hash(slotOf(3).Bytes(), slotOf(5).Bytes())] = slotOf(33)
+	st[slotOf(9)] = slotOf(77)
+	st[slotOf(10)] = slotOf(8)
+	// list[1] starts 11 slots after list[0], the size of Outer
+	st[slotOf(11)] = slotOf(2)
+	second := dataSlot(slotOf(11), 11)
+	st[second] = slotOf(6)
+	st[rebaseSlot(second, slotOf(4))] = slotOf(1)
+	st[dataSlot(rebaseSlot(second, slotOf(4)), 0)] = slotOf(300)
+	st[rebaseSlot(second, slotOf(9))] = slotOf(55)
+	st[rebaseSlot(second, slotOf(10))] = slotOf(4)
+	// inners[2].b, every Inner takes two slots
+	st[slotOf(17)] = slotOf(99)
+	st[slotOf(18)] = slotOf(1)
+
+	checkQueries(t, structMembersLayout, st, []queryTest{
+		{path: "o.x", want: "5"},
+		{path: "o.arr", want: "[1 2 3 4 5]"},
+		{path: "o.arr[4]", want: "5"},
+		{path: "o.inner.a", want: "9"},
+		{path: "o.inner.b", want: "10"},
+		{path: "o.dyn", want: "[100 200]"},
+		{path: "o.m[3]", want: "33"},
+		{path: "o.m[4]", want: "0"},
+		{path: "o.pair[0].b", want: "0"},
+		{path: "o.pair[1].b", want: "77"},
+		{path: "o.tail", want: "8"},
+		{path: "list[0].x", want: "0"},
+		{path: "list[0].pair[1].b", want: "0"},
+		{path: "list[1].x", want: "6"},
+		{path: "list[1].dyn", want: "[300]"},
+		{path: "list[1].pair[1].b", want: "55"},
+		{path: "list[1].tail", want: "4"},
+		{path: "inners[2].b", want: "99"},
+		{path: "inners[1].b", want: "0"},
+		{path: "last", want: "1"},
+	})
+}
+
+func TestStructSizeFromNumberOfBytes(t *testing.T) {
+	c, err := NewContractFromLayout(common.Address{}, nil, structMembersLayout)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	// the members of Inner add up to 264 bits, it takes two whole slots
+	for name, want := range map[string]uint{"o": 352 * 8, "inners": 192 * 8} {
+		if got := c.Variables[name].Len(); got != want {
+			t.Errorf("len(%s) = %d bits, want %d", name, got, want)
+		}
+	}
+}
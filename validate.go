@@ -0,0 +1,75 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// LayoutIssue is a variable of a storage layout whose type is unsupported or malformed
+type LayoutIssue struct {
+	// Label is the variable name
+	Label string `json:"label"`
+
+	// Type is the type id of the variable, e.g. t_mapping(t_address,t_uint256) or HashMap[address, uint256]
+	Type string `json:"type"`
+
+	// Reason tells what cannot be decoded, it names the nested type at fault
+	Reason string `json:"reason"`
+}
+
+func (i LayoutIssue) String() string {
+	return fmt.Sprintf("%s (%s): %s", i.Label, i.Type, i.Reason)
+}
+
+// LayoutError is returned by a strict parse of a layout holding variables that cannot be decoded
+type LayoutError struct {
+	Issues []LayoutIssue
+}
+
+func (e *LayoutError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+	return fmt.Sprintf("invalid storage layout, %d variables cannot be decoded: %s", len(e.Issues), strings.Join(issues, "; "))
+}
+
+// ValidateStorageLayout reports every variable of a solc storage layout whose type is unsupported or
+// malformed, the error is only set when layOutJson is not a storage layout
+func ValidateStorageLayout(layOutJson string) ([]LayoutIssue, error) {
+	var layout StorageLayout
+	if err := json.Unmarshal([]byte(layOutJson), &layout); err != nil {
+		return nil, fmt.Errorf("parse storage layout error: %v", err)
+	}
+	_, issues := layout.buildVariables(layout.Storage)
+	return issues, nil
+}
+
+// buildVariables builds the variables of storage with the types of l, the variables that cannot be
+// built are left out and reported
+func (l StorageLayout) buildVariables(storage []Storage) (map[string]Variable, []LayoutIssue) {
+	variables := make(map[string]Variable, len(storage))
+	var issues []LayoutIssue
+	for _, s := range storage {
+		sb, ok := new(big.Int).SetString(s.Slot, 10)
+		if !ok {
+			issues = append(issues, LayoutIssue{Label: s.Label, Type: s.Type, Reason: fmt.Sprintf("invalid slot %q", s.Slot)})
+			continue
+		}
+		if s.Offset >= 32 {
+			issues = append(issues, LayoutIssue{Label: s.Label, Type: s.Type, Reason: fmt.Sprintf("invalid offset %d", s.Offset)})
+			continue
+		}
+		v, err := l.getVariableByVariableType(s.Type, common.BigToHash(sb), uint(s.Offset*8), map[string]bool{})
+		if err != nil {
+			issues = append(issues, LayoutIssue{Label: s.Label, Type: s.Type, Reason: err.Error()})
+			continue
+		}
+		variables[s.Label] = v
+	}
+	return variables, issues
+}
@@ -0,0 +1,128 @@
+package storagescan
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// struct Node { uint256 v; mapping(uint256 => Node) kids; Node[] children; } Node root;
+const nodeLayout = `{"storage":[
+{"label":"root","offset":0,"slot":"0","type":"t_struct(Node)5_storage"}],
+"types":{
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_struct(Node)5_storage":{"encoding":"inplace","label":"struct Node","numberOfBytes":"96","members":[
+{"label":"v","offset":0,"slot":"0","type":"t_uint256"},
+{"label":"kids","offset":0,"slot":"1","type":"t_mapping(t_uint256,t_struct(Node)5_storage)"},
+{"label":"children","offset":0,"slot":"2","type":"t_array(t_struct(Node)5_storage)dyn_storage"}]},
+"t_mapping(t_uint256,t_struct(Node)5_storage)":{"encoding":"mapping","key":"t_uint256","label":"mapping(uint256 => struct Node)","numberOfBytes":"32","value":"t_struct(Node)5_storage"},
+"t_array(t_struct(Node)5_storage)dyn_storage":{"base":"t_struct(Node)5_storage","encoding":"dynamic_array","label":"struct Node[]","numberOfBytes":"32"}}}`
+
+func TestRecursiveStructLayout(t *testing.T) {
+	st := testStorage{}
+	st[slotOf(0)] = slotOf(7)
+	// root.kids[1].v, then root.kids[1].kids[2].v
+	kid := crypto.Keccak256Hash(slotOf(1).Bytes(), slotOf(1).Bytes())
+	st[kid] = slotOf(9)
+	grandKid := crypto.Keccak256Hash(slotOf(2).Bytes(), rebaseSlot(kid, slotOf(1)).Bytes())
+	st[grandKid] = slotOf(10)
+	// root.children = [{v: 11, children: [{v: 12}]}]
+	st[slotOf(2)] = slotOf(1)
+	child := dataSlot(slotOf(2), 0)
+	st[child] = slotOf(11)
+	st[rebaseSlot(child, slotOf(2))] = slotOf(1)
+	st[dataSlot(rebaseSlot(child, slotOf(2)), 0)] = slotOf(12)
+
+	checkQueries(t, nodeLayout, st, []queryTest{
+		{path: "root.v", want: "7"},
+		{path: "root.kids[1].v", want: "9"},
+		{path: "root.kids[1].kids[2].v", want: "10"},
+		{path: "root.kids[3].v", want: "0"},
+		{path: "root.children[0].v", want: "11"},
+		{path: "root.children[0].children[0].v", want: "12"},
+	})
+}
+
+func TestStaticSelfNestingRejected(t *testing.T) {
+	for name, member := range map[string]string{
+		"member":       `{"label":"self","offset":0,"slot":"0","type":"t_struct(Bad)3_storage"}`,
+		"static array": `{"label":"arr","offset":0,"slot":"0","type":"t_array(t_struct(Bad)3_storage)2_storage"}`,
+	} {
+		layout := `{"storage":[{"label":"bad","offset":0,"slot":"0","type":"t_struct(Bad)3_storage"}],
+"types":{
+"t_struct(Bad)3_storage":{"encoding":"inplace","label":"struct Bad","numberOfBytes":"64","members":[` + member + `]},
+"t_array(t_struct(Bad)3_storage)2_storage":{"base":"t_struct(Bad)3_storage","encoding":"inplace","label":"struct Bad[2]","numberOfBytes":"128"}}}`
+		_, err := NewContractFromLayout(common.Address{}, nil, layout)
+		var layoutErr *LayoutError
+		if !errors.As(err, &layoutErr) {
+			t.Fatalf("%s: want a layout error, got %v", name, err)
+		}
+		if len(layoutErr.Issues) != 1 || !strings.Contains(layoutErr.Issues[0].Reason, "holds itself") {
+			t.Errorf("%s: unexpected issues %v", name, layoutErr.Issues)
+		}
+	}
+}
+
+// ufixed128x18 fx; function() external fn; Price price; mapping(bool => uint256) flags; uint256 ok;
+// mapping(address => ufixed128x18) m; with type Price is uint256
+const invalidTypesLayout = `{"storage":[
+{"label":"fx","offset":0,"slot":"0","type":"t_ufixed128x18"},
+{"label":"fn","offset":0,"slot":"1","type":"t_function_external_nonpayable$__$returns$__$"},
+{"label":"price","offset":0,"slot":"2","type":"t_userDefinedValueType(Price)12"},
+{"label":"flags","offset":0,"slot":"3","type":"t_mapping(t_bool,t_uint256)"},
+{"label":"ok","offset":0,"slot":"4","type":"t_uint256"},
+{"label":"m","offset":0,"slot":"5","type":"t_mapping(t_address,t_ufixed128x18)"}],
+"types":{
+"t_bool":{"encoding":"inplace","label":"bool","numberOfBytes":"1"},
+"t_address":{"encoding":"inplace","label":"address","numberOfBytes":"20"},
+"t_uint256":{"encoding":"inplace","label":"uint256","numberOfBytes":"32"},
+"t_ufixed128x18":{"encoding":"inplace","label":"ufixed128x18","numberOfBytes":"32"},
+"t_function_external_nonpayable$__$returns$__$":{"encoding":"inplace","label":"function () external","numberOfBytes":"24"},
+"t_userDefinedValueType(Price)12":{"encoding":"inplace","label":"Price","numberOfBytes":"32"},
+"t_mapping(t_bool,t_uint256)":{"encoding":"mapping","key":"t_bool","label":"mapping(bool => uint256)","numberOfBytes":"32","value":"t_uint256"},
+"t_mapping(t_address,t_ufixed128x18)":{"encoding":"mapping","key":"t_address","label":"mapping(address => ufixed128x18)","numberOfBytes":"32","value":"t_ufixed128x18"}}}`
+
+func TestLayoutIssues(t *testing.T) {
+	want := []LayoutIssue{
+		{Label: "fx", Type: "t_ufixed128x18", Reason: "unsupported type t_ufixed128x18 (ufixed128x18)"},
+		{Label: "fn", Type: "t_function_external_nonpayable$__$returns$__$", Reason: "unsupported type t_function_external_nonpayable$__$returns$__$ (function () external)"},
+		{Label: "price", Type: "t_userDefinedValueType(Price)12", Reason: "unsupported type t_userDefinedValueType(Price)12 (Price)"},
+		{Label: "m", Type: "t_mapping(t_address,t_ufixed128x18)", Reason: "unsupported type t_ufixed128x18 (ufixed128x18)"},
+	}
+	issues, err := ValidateStorageLayout(invalidTypesLayout)
+	if err != nil || !reflect.DeepEqual(issues, want) {
+		t.Fatalf("ValidateStorageLayout = %+v, %v, want %+v", issues, err, want)
+	}
+
+	_, err = NewContractFromLayout(common.Address{}, nil, invalidTypesLayout)
+	var layoutErr *LayoutError
+	if !errors.As(err, &layoutErr) || !reflect.DeepEqual(layoutErr.Issues, want) {
+		t.Fatalf("strict parse error = %v, want the issues of ValidateStorageLayout", err)
+	}
+
+	st := testStorage{slotOf(4): slotOf(4)}
+	st[crypto.Keccak256Hash(slotOf(1).Bytes(), slotOf(3).Bytes())] = slotOf(10)
+	st[crypto.Keccak256Hash(slotOf(0).Bytes(), slotOf(3).Bytes())] = slotOf(20)
+	c := NewContractWithReader(common.Address{}, NewSnapshotReader(common.Address{}, st))
+	c.Lenient = true
+	if err = c.ParseByStorageLayout(invalidTypesLayout); err != nil {
+		t.Fatalf("lenient parse error: %v", err)
+	}
+	if !reflect.DeepEqual(c.Skipped, want) || len(c.Variables) != 2 {
+		t.Fatalf("skipped = %+v, %d variables, want the issues of ValidateStorageLayout and 2 variables", c.Skipped, len(c.Variables))
+	}
+	for path, want := range map[string]string{"ok": "4", "flags[true]": "10", "flags[1]": "10", "flags[false]": "20", "flags[0]": "20"} {
+		v, err := c.Query(path)
+		if err != nil || fmt.Sprint(v) != want {
+			t.Errorf("query %s = %v, %v, want %s", path, v, err, want)
+		}
+	}
+	if _, err = c.Query("flags[yes]"); err == nil || !strings.Contains(err.Error(), "invalid bool") {
+		t.Errorf("query flags[yes] error = %v, want invalid bool", err)
+	}
+}
@@ -0,0 +1,202 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"math/big"
+)
+
+// Value is a decoded elementary value, composite values are returned as StructValueI, SliceArrayValueI
+// or MappingValueI and yield Values from Field, Index and Key
+type Value interface {
+	// Big returns the value as an integer, byte values are read as a big-endian unsigned integer
+	Big() *big.Int
+
+	// Hex returns the 0x prefixed hex form of the value
+	Hex() string
+
+	// Bytes returns the binary form of the value, integers and bools are returned as a 32 bytes word
+	Bytes() []byte
+
+	// Interface returns the plain Go value: *big.Int, bool, common.Address, []byte or string
+	Interface() interface{}
+
+	String() string
+}
+
+// UintValue is a uintN or enum value
+type UintValue struct {
+	*big.Int
+}
+
+func (v UintValue) Big() *big.Int {
+	return new(big.Int).Set(v.Int)
+}
+
+func (v UintValue) Hex() string {
+	return hexutil.EncodeBig(v.Int)
+}
+
+func (v UintValue) Bytes() []byte {
+	return common.BigToHash(v.Int).Bytes()
+}
+
+func (v UintValue) Interface() interface{} {
+	return v.Big()
+}
+
+// MarshalJSON writes the decimal string, integers wider than 53 bits do not survive JSON numbers
+func (v UintValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Int.String())
+}
+
+// IntValue is an intN value
+type IntValue struct {
+	*big.Int
+}
+
+func (v IntValue) Big() *big.Int {
+	return new(big.Int).Set(v.Int)
+}
+
+// Hex returns the signed hex form, e.g. -0x8
+func (v IntValue) Hex() string {
+	return hexutil.EncodeBig(v.Int)
+}
+
+// Bytes returns the value as a two's complement 32 bytes word
+func (v IntValue) Bytes() []byte {
+	return math.U256Bytes(v.Big())
+}
+
+func (v IntValue) Interface() interface{} {
+	return v.Big()
+}
+
+func (v IntValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Int.String())
+}
+
+type BoolValue bool
+
+func (v BoolValue) Big() *big.Int {
+	if v {
+		return big.NewInt(1)
+	}
+	return new(big.Int)
+}
+
+func (v BoolValue) Hex() string {
+	return hexutil.EncodeBig(v.Big())
+}
+
+func (v BoolValue) Bytes() []byte {
+	return common.BigToHash(v.Big()).Bytes()
+}
+
+func (v BoolValue) Interface() interface{} {
+	return bool(v)
+}
+
+func (v BoolValue) String() string {
+	if v {
+		return "true"
+	}
+	return "false"
+}
+
+// AddressValue is an address or contract value, Hex and String return the checksummed address
+type AddressValue struct {
+	common.Address
+}
+
+func (v AddressValue) Big() *big.Int {
+	return new(big.Int).SetBytes(v.Address.Bytes())
+}
+
+func (v AddressValue) Interface() interface{} {
+	return v.Address
+}
+
+func (v AddressValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Hex())
+}
+
+// BytesNValue is a bytes1..bytes32 value holding exactly N bytes
+type BytesNValue []byte
+
+func (v BytesNValue) Big() *big.Int {
+	return new(big.Int).SetBytes(v)
+}
+
+func (v BytesNValue) Hex() string {
+	return hexutil.Encode(v)
+}
+
+func (v BytesNValue) Bytes() []byte {
+	return common.CopyBytes(v)
+}
+
+func (v BytesNValue) Interface() interface{} {
+	return v.Bytes()
+}
+
+func (v BytesNValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Hex())
+}
+
+func (v BytesNValue) String() string {
+	return v.Hex()
+}
+
+// DynamicBytesValue is a bytes value
+type DynamicBytesValue []byte
+
+func (v DynamicBytesValue) Big() *big.Int {
+	return new(big.Int).SetBytes(v)
+}
+
+func (v DynamicBytesValue) Hex() string {
+	return hexutil.Encode(v)
+}
+
+func (v DynamicBytesValue) Bytes() []byte {
+	return common.CopyBytes(v)
+}
+
+func (v DynamicBytesValue) Interface() interface{} {
+	return v.Bytes()
+}
+
+func (v DynamicBytesValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Hex())
+}
+
+func (v DynamicBytesValue) String() string {
+	return v.Hex()
+}
+
+// StringValue is a string value, Bytes and Hex return its UTF-8 encoding
+type StringValue string
+
+func (v StringValue) Big() *big.Int {
+	return new(big.Int).SetBytes([]byte(v))
+}
+
+func (v StringValue) Hex() string {
+	return hexutil.Encode([]byte(v))
+}
+
+func (v StringValue) Bytes() []byte {
+	return []byte(v)
+}
+
+func (v StringValue) Interface() interface{} {
+	return string(v)
+}
+
+func (v StringValue) String() string {
+	return string(v)
+}
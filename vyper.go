@@ -0,0 +1,371 @@
+package storagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vyper stores every variable, array element and struct member from the start of a new slot, nothing is packed
+
+var (
+	vyperIntRegexp   = regexp.MustCompile(`^(uint|int|bytes)(\d+)$`)
+	vyperSizedRegexp = regexp.MustCompile(`^(String|Bytes)\[(\d+)\]$`)
+)
+
+type vyperStorage struct {
+	Type string `json:"type"`
+	Slot uint64 `json:"slot"`
+}
+
+// ParseByVyperLayout parses the variables of the output of vyper -f layout, replacing a previous parse, either {"storage_layout": {...}, "code_layout": {...}}
+// or the bare {"name": {"type": "HashMap[address, uint256]", "slot": 1}} map of older versions,
+// variables of modules are named module.name
+func (c *Contract) ParseByVyperLayout(layoutJson string) error {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(layoutJson), &top); err != nil {
+		return fmt.Errorf("parse vyper layout error: %v", err)
+	}
+	if storage, ok := top["storage_layout"]; ok {
+		top = nil
+		if err := json.Unmarshal(storage, &top); err != nil {
+			return fmt.Errorf("parse vyper layout error: %v", err)
+		}
+	}
+	variables := map[string]Variable{}
+	var issues []LayoutIssue
+	if err := parseVyperStorage(variables, &issues, top, ""); err != nil {
+		return err
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Label < issues[j].Label
+	})
+	if len(issues) > 0 && !c.Lenient {
+		return &LayoutError{Issues: issues}
+	}
+	c.StorageLayout, c.Variables, c.Skipped = StorageLayout{}, variables, issues
+	return nil
+}
+
+// parseVyperStorage adds the variables of storage to variables and the ones of unsupported types to
+// issues, prefix is the module path of storage
+func parseVyperStorage(variables map[string]Variable, issues *[]LayoutIssue, storage map[string]json.RawMessage, prefix string) error {
+	for name, raw := range storage {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("parse vyper variable %s%s error: %v", prefix, name, err)
+		}
+		if _, ok := entry["type"]; !ok {
+			// module storage
+			if err := parseVyperStorage(variables, issues, entry, prefix+name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		var s vyperStorage
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("parse vyper variable %s%s error: %v", prefix, name, err)
+		}
+		if s.Type == "nonreentrant lock" {
+			continue
+		}
+		v, err := parseVyperType(s.Type)
+		if err != nil {
+			*issues = append(*issues, LayoutIssue{Label: prefix + name, Type: s.Type, Reason: err.Error()})
+			continue
+		}
+		variables[prefix+name] = v.Rebase(common.BigToHash(new(big.Int).SetUint64(s.Slot)))
+	}
+	return nil
+}
+
+// parseVyperType builds the variable of a vyper type at slot zero, e.g. uint256, bytes4, String[64],
+// DynArray[address, 10], HashMap[address, HashMap[address, uint256]], uint256[3], interface ERC20 read
+// as an address or flag Roles read as a uint256, structs are not supported
+func parseVyperType(typ string) (Variable, error) {
+	typ = strings.TrimSpace(typ)
+	switch typ {
+	case "bool":
+		return &SolidityBool{}, nil
+	case "address":
+		return &SolidityAddress{}, nil
+	case "decimal":
+		// the value scaled by 10^10
+		return &SolidityInt{Length: 168}, nil
+	}
+	if m := vyperIntRegexp.FindStringSubmatch(typ); m != nil {
+		length, _ := strconv.ParseUint(m[2], 10, 64)
+		switch {
+		case m[1] == "bytes" && length >= 1 && length <= 32:
+			// bytesM is left aligned in its slot
+			return &SolidityBytes{Length: uint(length * 8), Offset: uint(256 - length*8)}, nil
+		case m[1] != "bytes" && length >= 8 && length <= 256 && length%8 == 0:
+			if m[1] == "int" {
+				return &SolidityInt{Length: uint(length)}, nil
+			}
+			return &SolidityUint{Length: uint(length)}, nil
+		}
+		return nil, fmt.Errorf("invalid vyper type %s", typ)
+	}
+	if m := vyperSizedRegexp.FindStringSubmatch(typ); m != nil {
+		maxLength, _ := strconv.ParseUint(m[2], 10, 64)
+		return &VyperBytes{MaxLength: maxLength, IsString: m[1] == "String"}, nil
+	}
+
+	if strings.HasPrefix(typ, "HashMap[") && strings.HasSuffix(typ, "]") {
+		args := splitVyperArgs(typ[len("HashMap[") : len(typ)-1])
+		if len(args) != 2 {
+			return nil, fmt.Errorf("invalid vyper type %s", typ)
+		}
+		key, err := parseVyperType(args[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseVyperType(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &VyperHashMap{KeyTyp: key.Typ(), ValueTyp: value}, nil
+	}
+	if strings.HasPrefix(typ, "DynArray[") && strings.HasSuffix(typ, "]") {
+		args := splitVyperArgs(typ[len("DynArray[") : len(typ)-1])
+		if len(args) != 2 {
+			return nil, fmt.Errorf("invalid vyper type %s", typ)
+		}
+		unit, err := parseVyperType(args[0])
+		if err != nil {
+			return nil, err
+		}
+		maxLength, err := strconv.ParseUint(strings.TrimSpace(args[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vyper type %s", typ)
+		}
+		return &VyperDynArray{UnitTyp: unit, MaxLength: maxLength}, nil
+	}
+	if strings.HasSuffix(typ, "]") {
+		// static array, the last dimension is the outer one, e.g. uint256[3][2] is two uint256[3]
+		open := strings.LastIndex(typ, "[")
+		if open > 0 {
+			length, err := strconv.ParseUint(typ[open+1:len(typ)-1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vyper type %s", typ)
+			}
+			unit, err := parseVyperType(typ[:open])
+			if err != nil {
+				return nil, err
+			}
+			return &VyperArray{UnitTyp: unit, UnitLength: length}, nil
+		}
+	}
+	// user defined types are named with their kind, e.g. "interface ERC20" or "flag Roles"
+	if kind := strings.SplitN(typ, " ", 2); len(kind) == 2 {
+		switch kind[0] {
+		case "interface":
+			return &SolidityAddress{}, nil
+		case "flag", "enum":
+			// the bitmask of the members set
+			return &SolidityUint{Length: 256}, nil
+		case "struct":
+			return nil, fmt.Errorf("unsupported vyper type %s, the layout does not list struct members", typ)
+		}
+	}
+	return nil, fmt.Errorf("unsupported vyper type %s", typ)
+}
+
+// splitVyperArgs splits the comma separated type arguments at the top bracket level
+func splitVyperArgs(args string) []string {
+	var parts []string
+	depth, begin := 0, 0
+	for i, c := range args {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	return append(parts, args[begin:])
+}
+
+// VyperBytes is the vyper String[N] or Bytes[N] type, the length is stored at the slot and the content
+// in the following slots
+type VyperBytes struct {
+	SlotIndex common.Hash
+
+	// MaxLength is N, the maximum length in bytes
+	MaxLength uint64
+
+	IsString bool
+}
+
+func (s VyperBytes) Typ() SolidityTyp {
+	if s.IsString {
+		return StringTy
+	}
+	return DynamicBytesTy
+}
+
+func (s VyperBytes) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
+	length := common.BytesToHash(v).Big()
+	if !length.IsUint64() || length.Uint64() > s.MaxLength {
+		return nil, fmt.Errorf("invalid vyper bytes length %v at slot %s, maximum %d", length, s.SlotIndex.Hex(), s.MaxLength)
+	}
+	value := make([]byte, 0, length.Uint64()+31)
+	for i := uint64(1); uint64(len(value)) < length.Uint64(); i++ {
+		word, err := f(rebaseSlot(s.SlotIndex, common.BigToHash(new(big.Int).SetUint64(i))))
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, common.BytesToHash(word).Bytes()...)
+	}
+	value = value[:length.Uint64()]
+	if s.IsString {
+		return StringValue(value), nil
+	}
+	return DynamicBytesValue(value), nil
+}
+
+func (s VyperBytes) Len() uint {
+	return uint(1+(s.MaxLength+31)/32) * 256
+}
+
+func (s VyperBytes) Slot() common.Hash {
+	return s.SlotIndex
+}
+
+func (s VyperBytes) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
+// VyperDynArray is the vyper DynArray[T, N] type, the length is stored at the slot and the elements
+// in the following slots
+type VyperDynArray struct {
+	SlotIndex common.Hash
+
+	UnitTyp Variable `json:"unit_typ"`
+
+	// MaxLength is N, the maximum number of elements
+	MaxLength uint64
+}
+
+func (s VyperDynArray) Typ() SolidityTyp {
+	return SliceTy
+}
+
+func (s VyperDynArray) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	v, err := f(s.SlotIndex)
+	if err != nil {
+		return nil, err
+	}
+	length := common.BytesToHash(v).Big()
+	if !length.IsUint64() || length.Uint64() > s.MaxLength {
+		return nil, fmt.Errorf("invalid vyper array length %v at slot %s, maximum %d", length, s.SlotIndex.Hex(), s.MaxLength)
+	}
+	return VariableSliceValue{
+		slotIndex: rebaseSlot(s.SlotIndex, common.BigToHash(big.NewInt(1))),
+		unitTyp:   s.UnitTyp,
+		unitSlots: storageSlots(s.UnitTyp),
+		length:    length.Uint64(),
+		f:         f,
+	}, nil
+}
+
+func (s VyperDynArray) Len() uint {
+	return uint(1+s.MaxLength*storageSlots(s.UnitTyp)) * 256
+}
+
+func (s VyperDynArray) Slot() common.Hash {
+	return s.SlotIndex
+}
+
+func (s VyperDynArray) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
+// VyperArray is the vyper T[N] type, the elements are stored in consecutive slots without packing
+type VyperArray struct {
+	SlotIndex common.Hash
+
+	UnitTyp Variable `json:"unit_typ"`
+
+	UnitLength uint64 `json:"unit_length"`
+}
+
+func (s VyperArray) Typ() SolidityTyp {
+	return ArrayTy
+}
+
+func (s VyperArray) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	return VariableSliceValue{
+		slotIndex: s.SlotIndex,
+		unitTyp:   s.UnitTyp,
+		unitSlots: storageSlots(s.UnitTyp),
+		length:    s.UnitLength,
+		f:         f,
+	}, nil
+}
+
+func (s VyperArray) Len() uint {
+	return uint(s.UnitLength*storageSlots(s.UnitTyp)) * 256
+}
+
+func (s VyperArray) Slot() common.Hash {
+	return s.SlotIndex
+}
+
+func (s VyperArray) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
+
+// VyperHashMap is the vyper HashMap[K, V] type
+type VyperHashMap struct {
+	SlotIndex common.Hash
+
+	KeyTyp SolidityTyp
+
+	ValueTyp Variable `json:"value_typ"`
+}
+
+func (s VyperHashMap) Typ() SolidityTyp {
+	return MappingTy
+}
+
+func (s VyperHashMap) Value(f GetValueStorageAtFunc) (interface{}, error) {
+	return MappingValue{
+		baseSlotIndex: s.SlotIndex,
+		keyTyp:        s.KeyTyp,
+		valueTyp:      s.ValueTyp,
+		f:             f,
+		vyper:         true,
+	}, nil
+}
+
+func (s VyperHashMap) Len() uint {
+	return 256
+}
+
+func (s VyperHashMap) Slot() common.Hash {
+	return s.SlotIndex
+}
+
+func (s VyperHashMap) Rebase(base common.Hash) Variable {
+	s.SlotIndex = rebaseSlot(base, s.SlotIndex)
+	return &s
+}
@@ -0,0 +1,107 @@
+package storagescan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const vyperLayout = `{"storage_layout":{
+"owner":{"type":"address","slot":0},
+"token":{"type":"interface ERC20","slot":1},
+"roles":{"type":"flag Roles","slot":2},
+"tag":{"type":"bytes4","slot":3},
+"name":{"type":"String[64]","slot":4},
+"data":{"type":"Bytes[40]","slot":7},
+"nums":{"type":"DynArray[uint256, 3]","slot":10},
+"balances":{"type":"HashMap[address, uint256]","slot":14},
+"byName":{"type":"HashMap[String[10], uint256]","slot":15},
+"byBytes":{"type":"HashMap[Bytes[10], uint256]","slot":16},
+"lists":{"type":"HashMap[uint256, DynArray[uint8, 4]]","slot":17},
+"lock":{"type":"nonreentrant lock","slot":18},
+"ownable":{"owner":{"type":"address","slot":19}}}}`
+
+func TestVyperEncoding(t *testing.T) {
+	owner := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	name := strings.Repeat("vyper", 8)
+	st := testStorage{}
+	st[slotOf(0)] = common.BytesToHash(owner.Bytes())
+	st[slotOf(1)] = common.HexToHash("0xbb")
+	st[slotOf(2)] = slotOf(5)
+	// bytesN is left aligned
+	st[slotOf(3)] = common.BytesToHash(common.RightPadBytes(common.FromHex("0xdeadbeef"), 32))
+	// String and Bytes hold their length at the slot and their content from the next slot
+	st[slotOf(4)] = slotOf(uint64(len(name)))
+	st[slotOf(5)] = common.BytesToHash([]byte(name[:32]))
+	st[slotOf(6)] = common.BytesToHash(common.RightPadBytes([]byte(name[32:]), 32))
+	st[slotOf(7)] = slotOf(2)
+	st[slotOf(8)] = common.BytesToHash(common.RightPadBytes([]byte{0xbe, 0xef}, 32))
+	// DynArray holds its length at the slot and one element per slot from the next slot
+	st[slotOf(10)] = slotOf(2)
+	st[slotOf(11)] = slotOf(7)
+	st[slotOf(12)] = slotOf(8)
+	st[slotOf(13)] = slotOf(9)
+	// HashMap values are at keccak256(slot . key), String and Bytes keys are hashed first
+	st[crypto.Keccak256Hash(slotOf(14).Bytes(), common.LeftPadBytes(owner.Bytes(), 32))] = slotOf(100)
+	st[crypto.Keccak256Hash(slotOf(15).Bytes(), crypto.Keccak256([]byte("bob")))] = slotOf(200)
+	st[crypto.Keccak256Hash(slotOf(16).Bytes(), crypto.Keccak256([]byte{0xbe, 0xef}))] = slotOf(300)
+	list := crypto.Keccak256Hash(slotOf(17).Bytes(), slotOf(3).Bytes())
+	st[list] = slotOf(2)
+	st[rebaseSlot(list, slotOf(1))] = slotOf(4)
+	st[rebaseSlot(list, slotOf(2))] = slotOf(5)
+	st[slotOf(19)] = common.HexToHash("0xcc")
+
+	checkParsedQueries(t, st, []queryTest{
+		{path: "owner", want: owner.Hex()},
+		{path: "token", want: common.HexToAddress("0xbb").Hex()},
+		{path: "roles", want: "5"},
+		{path: "tag", want: "0xdeadbeef"},
+		{path: "name", want: name},
+		{path: "data", want: "0xbeef"},
+		{path: "nums", want: "[7 8]"},
+		{path: "nums[1]", want: "8"},
+		{path: "balances[" + owner.Hex() + "]", want: "100"},
+		{path: "balances[0x00000000000000000000000000000000000000bb]", want: "0"},
+		{path: "byName[bob]", want: "200"},
+		{path: "byBytes[0xbeef]", want: "300"},
+		{path: "lists[3]", want: "[4 5]"},
+		{path: "lists[4]", want: "[]"},
+		{path: "ownable.owner", want: common.HexToAddress("0xcc").Hex()},
+	}, func(reader StorageReader) (*Contract, error) {
+		c := NewContractWithReader(common.Address{}, reader)
+		return c, c.ParseByVyperLayout(vyperLayout)
+	})
+}
+
+func TestVyperLengthBeyondMaximum(t *testing.T) {
+	st := testStorage{slotOf(4): slotOf(65), slotOf(10): slotOf(4)}
+	c := NewContractWithReader(common.Address{}, NewSnapshotReader(common.Address{}, st))
+	if err := c.ParseByVyperLayout(vyperLayout); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"name", "nums"} {
+		if _, err := c.GetVariableValue(name); err == nil || !strings.Contains(err.Error(), "maximum") {
+			t.Errorf("%s error = %v, want a length beyond the maximum", name, err)
+		}
+	}
+}
+
+func TestVyperStructUnsupported(t *testing.T) {
+	layout := `{"point":{"type":"struct Point","slot":0},"owner":{"type":"address","slot":1}}`
+	c := NewContractWithReader(common.Address{}, nil)
+	err := c.ParseByVyperLayout(layout)
+	var layoutErr *LayoutError
+	if !errors.As(err, &layoutErr) || len(layoutErr.Issues) != 1 || layoutErr.Issues[0].Label != "point" {
+		t.Fatalf("strict parse error = %v, want the struct reported", err)
+	}
+	c.Lenient = true
+	if err = c.ParseByVyperLayout(layout); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Variables["owner"]; !ok || len(c.Skipped) != 1 {
+		t.Errorf("variables = %v, skipped = %v, want owner parsed and point skipped", c.Variables, c.Skipped)
+	}
+}